@@ -0,0 +1,186 @@
+package scp
+
+import "sort"
+
+// nodeIndex interns node IDs as small integers, so that sets of nodes
+// can be represented as bitsets during quorum searches. Each Slot has
+// its own, which only ever holds the node and the senders of the
+// slot's messages. It also holds scratch space reused from one search
+// to the next, so it must not be used by two searches at once.
+type nodeIndex struct {
+	index map[NodeID]int
+	ids   []NodeID
+
+	in    nodeBits
+	trail []int
+}
+
+func newNodeIndex() *nodeIndex {
+	return &nodeIndex{index: make(map[NodeID]int)}
+}
+
+// Tells the index of the given node ID, assigning a new one if
+// necessary.
+func (x *nodeIndex) get(id NodeID) int {
+	if i, ok := x.index[id]; ok {
+		return i
+	}
+	i := len(x.ids)
+	x.index[id] = i
+	x.ids = append(x.ids, id)
+	return i
+}
+
+// nodeBits is a set of node indexes.
+type nodeBits []uint64
+
+func (b nodeBits) has(i int) bool {
+	w := i / 64
+	return w < len(b) && b[w]&(1<<uint(i%64)) != 0
+}
+
+func (b *nodeBits) set(i int) {
+	w := i / 64
+	for w >= len(*b) {
+		*b = append(*b, 0)
+	}
+	(*b)[w] |= 1 << uint(i%64)
+}
+
+func (b nodeBits) clear(i int) {
+	if w := i / 64; w < len(b) {
+		b[w] &^= 1 << uint(i%64)
+	}
+}
+
+// A quorumSearch finds quorums and blocking sets among the senders of
+// msgs. It builds its result in place, in a bitset plus a trail of
+// the indexes added in order, and backtracks by truncating the
+// trail. This avoids allocating a new NodeIDSet at each step.
+type quorumSearch struct {
+	x    *nodeIndex
+	msgs map[NodeID]*Msg
+}
+
+func newQuorumSearch(x *nodeIndex, msgs map[NodeID]*Msg) *quorumSearch {
+	x.trail = x.trail[:0]
+	for i := range x.in {
+		x.in[i] = 0
+	}
+	return &quorumSearch{x: x, msgs: msgs}
+}
+
+func (qs *quorumSearch) add(id NodeID) {
+	i := qs.x.get(id)
+	if !qs.x.in.has(i) {
+		qs.x.in.set(i)
+		qs.x.trail = append(qs.x.trail, i)
+	}
+}
+
+func (qs *quorumSearch) contains(id NodeID) bool {
+	i, ok := qs.x.index[id]
+	return ok && qs.x.in.has(i)
+}
+
+// Removes the nodes added since the trail had the given length.
+func (qs *quorumSearch) undo(mark int) {
+	for len(qs.x.trail) > mark {
+		last := len(qs.x.trail) - 1
+		qs.x.in.clear(qs.x.trail[last])
+		qs.x.trail = qs.x.trail[:last]
+	}
+}
+
+// Produces the nodes found, as a NodeIDSet.
+func (qs *quorumSearch) result() NodeIDSet {
+	if len(qs.x.trail) == 0 {
+		return nil
+	}
+	result := make(NodeIDSet, 0, len(qs.x.trail))
+	for _, i := range qs.x.trail {
+		result = append(result, qs.x.ids[i])
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Less(result[j]) })
+	return result
+}
+
+// Finds needed members that satisfy pred, or that (if nested QSets)
+// contain blocking sets satisfying it.
+func (qs *quorumSearch) blockingSet(needed int, members []QSetMember, pred predicate) (bool, predicate) {
+	if needed == 0 {
+		return true, pred
+	}
+	if needed > len(members) {
+		return false, pred
+	}
+	mark := len(qs.x.trail)
+	m0 := members[0]
+	switch {
+	case m0.N != nil:
+		if msg, ok := qs.msgs[*m0.N]; ok {
+			if nextPred := pred.test(msg); nextPred != nil {
+				qs.add(*m0.N)
+				ok, pred2 := qs.blockingSet(needed-1, members[1:], nextPred)
+				if !ok {
+					qs.undo(mark)
+				}
+				return ok, pred2
+			}
+		}
+
+	case m0.Q != nil:
+		if ok, pred2 := qs.blockingSet(len(m0.Q.M)-m0.Q.T+1, m0.Q.M, pred); ok && len(qs.x.trail) > 0 {
+			ok, pred3 := qs.blockingSet(needed-1, members[1:], pred2)
+			if !ok {
+				qs.undo(mark)
+			}
+			return ok, pred3
+		}
+		qs.undo(mark)
+	}
+	return qs.blockingSet(needed, members[1:], pred)
+}
+
+// Finds threshold members that satisfy pred and whose own slices,
+// recursively, do too.
+func (qs *quorumSearch) quorum(threshold int, members []QSetMember, pred predicate) (bool, predicate) {
+	if threshold == 0 {
+		return true, pred
+	}
+	if threshold > len(members) {
+		return false, pred
+	}
+	mark := len(qs.x.trail)
+	m0 := members[0]
+	switch {
+	case m0.N != nil:
+		if qs.contains(*m0.N) {
+			return qs.quorum(threshold-1, members[1:], pred)
+		}
+		if msg, ok := qs.msgs[*m0.N]; ok {
+			if nextPred := pred.test(msg); nextPred != nil {
+				qs.add(*m0.N)
+				if ok, pred2 := qs.quorum(msg.Q.T, msg.Q.M, nextPred); ok {
+					ok, pred3 := qs.quorum(threshold-1, members[1:], pred2)
+					if !ok {
+						qs.undo(mark)
+					}
+					return ok, pred3
+				}
+				qs.undo(mark)
+			}
+		}
+
+	case m0.Q != nil:
+		if ok, pred2 := qs.quorum(m0.Q.T, m0.Q.M, pred); ok {
+			ok, pred3 := qs.quorum(threshold-1, members[1:], pred2)
+			if !ok {
+				qs.undo(mark)
+			}
+			return ok, pred3
+		}
+		qs.undo(mark)
+	}
+	return qs.quorum(threshold, members[1:], pred)
+}
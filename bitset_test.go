@@ -0,0 +1,214 @@
+package scp
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// These are the slice-based quorum searches that the bitset-based
+// ones replaced, kept for comparison.
+
+func findBlockingSetSlices(needed int, members []QSetMember, msgs map[NodeID]*Msg, pred predicate, sofar NodeIDSet) (NodeIDSet, predicate) {
+	if needed == 0 {
+		return sofar, pred
+	}
+	if needed > len(members) {
+		return nil, pred
+	}
+	m0 := members[0]
+	switch {
+	case m0.N != nil:
+		if msg, ok := msgs[*m0.N]; ok {
+			if nextPred := pred.test(msg); nextPred != nil {
+				return findBlockingSetSlices(needed-1, members[1:], msgs, nextPred, sofar.Add(*m0.N))
+			}
+		}
+
+	case m0.Q != nil:
+		sofar2, pred2 := findBlockingSetSlices(len(m0.Q.M)-m0.Q.T+1, m0.Q.M, msgs, pred, sofar)
+		if len(sofar2) > 0 {
+			return findBlockingSetSlices(needed-1, members[1:], msgs, pred2, sofar2)
+		}
+	}
+	return findBlockingSetSlices(needed, members[1:], msgs, pred, sofar)
+}
+
+func findQuorumSlices(threshold int, members []QSetMember, msgs map[NodeID]*Msg, pred predicate, sofar NodeIDSet) (NodeIDSet, predicate) {
+	if threshold == 0 {
+		return sofar, pred
+	}
+	if threshold > len(members) {
+		return nil, pred
+	}
+	m0 := members[0]
+	switch {
+	case m0.N != nil:
+		if sofar.Contains(*m0.N) {
+			return findQuorumSlices(threshold-1, members[1:], msgs, pred, sofar)
+		}
+		if msg, ok := msgs[*m0.N]; ok {
+			if nextPred := pred.test(msg); nextPred != nil {
+				sofar2, pred2 := findQuorumSlices(msg.Q.T, msg.Q.M, msgs, nextPred, sofar.Add(*m0.N))
+				if len(sofar2) > 0 {
+					return findQuorumSlices(threshold-1, members[1:], msgs, pred2, sofar2)
+				}
+			}
+		}
+
+	case m0.Q != nil:
+		sofar2, pred2 := findQuorumSlices(m0.Q.T, m0.Q.M, msgs, pred, sofar)
+		if len(sofar2) > 0 {
+			return findQuorumSlices(threshold-1, members[1:], msgs, pred2, sofar2)
+		}
+	}
+	return findQuorumSlices(threshold, members[1:], msgs, pred, sofar)
+}
+
+// Produces a network of orgs organizations with size nodes each, in
+// which every node requires two-thirds of the organizations and
+// each organization requires a majority of its nodes. It returns the
+// latest message from each node (all but one of them, from which the
+// searches start) and the QSet of the remaining node.
+func orgsNetwork(orgs, size int) (NodeID, QSet, map[NodeID]*Msg) {
+	q := QSet{T: (2*orgs + 2) / 3}
+	for i := 0; i < orgs; i++ {
+		orgQ := QSet{T: size/2 + 1}
+		for j := 0; j < size; j++ {
+			id := NodeID(fmt.Sprintf("n%d-%d", i, j))
+			orgQ.M = append(orgQ.M, QSetMember{N: &id})
+		}
+		q.M = append(q.M, QSetMember{Q: &orgQ})
+	}
+	self := *q.M[0].Q.M[0].N
+	msgs := make(map[NodeID]*Msg)
+	for _, id := range q.Nodes() {
+		if id != self {
+			msgs[id] = &Msg{V: id, I: 1, Q: q}
+		}
+	}
+	return self, q, msgs
+}
+
+// Produces a random network of n nodes, each requiring t of k others.
+func randomNetwork(rng *rand.Rand, n, k, t int) (NodeID, QSet, map[NodeID]*Msg) {
+	ids := make([]NodeID, n)
+	for i := range ids {
+		ids[i] = NodeID(fmt.Sprintf("n%03d", i))
+	}
+	qset := func() QSet {
+		q := QSet{T: t}
+		for _, i := range rng.Perm(n)[:k] {
+			q.M = append(q.M, QSetMember{N: &ids[i]})
+		}
+		return q
+	}
+	msgs := make(map[NodeID]*Msg)
+	for _, id := range ids[1:] {
+		msgs[id] = &Msg{V: id, I: 1, Q: qset()}
+	}
+	return ids[0], qset(), msgs
+}
+
+// A predicate failing for every nth node in msgs, in sorted order.
+func everyNth(msgs map[NodeID]*Msg, n int) predicate {
+	var ids NodeIDSet
+	for id := range msgs {
+		ids = ids.Add(id)
+	}
+	fail := make(map[NodeID]bool)
+	for i := 0; i < len(ids); i += n {
+		fail[ids[i]] = true
+	}
+	return fpred(func(msg *Msg) bool { return !fail[msg.V] })
+}
+
+func TestQuorumSearchMatchesSlices(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	x := newNodeIndex()
+	for i := 0; i < 200; i++ {
+		self, q, msgs := randomNetwork(rng, 10+rng.Intn(30), 5, 2+rng.Intn(3))
+		if i%2 == 0 {
+			self, q, msgs = orgsNetwork(2+rng.Intn(5), 1+rng.Intn(4))
+		}
+		pred := everyNth(msgs, 2+rng.Intn(5))
+
+		want, _ := findQuorumSlices(q.T, q.M, msgs, pred, NodeIDSet{self})
+		got, _ := q.findQuorumIn(x, self, msgs, pred)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("case %d: quorum %v, want %v", i, got, want)
+		}
+
+		want, _ = findBlockingSetSlices(len(q.M)-q.T+1, q.M, msgs, pred, nil)
+		got, _ = q.findBlockingSetIn(x, msgs, pred)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("case %d: blocking set %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestSlotIndexHoldsSenders(t *testing.T) {
+	// The senders name many nodes that never speak.
+	var strangers NodeIDSet
+	for i := 0; i < 100; i++ {
+		strangers = strangers.Add(NodeID(fmt.Sprintf("s%d", i)))
+	}
+	node := NewNode("a", slicesToQSet([]NodeIDSet{{"b"}, {"c"}}), nil, nil)
+	slot, _ := newSlot(1, node)
+	for _, v := range []NodeID{"b", "c"} {
+		slot.M[v] = &Msg{V: v, I: 1, Q: slicesToQSet([]NodeIDSet{strangers, {"a"}})}
+	}
+	if got := slot.findQuorum(fpred(func(*Msg) bool { return true })); len(got) == 0 {
+		t.Fatal("no quorum")
+	}
+	slot.findBlockingSet(fpred(func(*Msg) bool { return false }))
+	if got := len(slot.index.ids); got > 3 {
+		t.Errorf("index holds %d IDs (%v), want at most 3", got, slot.index.ids)
+	}
+}
+
+func benchmarkQuorum(b *testing.B, orgs, size int, bitsets bool) {
+	self, q, msgs := orgsNetwork(orgs, size)
+	pred := everyNth(msgs, 5)
+	x := newNodeIndex()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var got NodeIDSet
+		if bitsets {
+			got, _ = q.findQuorumIn(x, self, msgs, pred)
+		} else {
+			got, _ = findQuorumSlices(q.T, q.M, msgs, pred, NodeIDSet{self})
+		}
+		if len(got) == 0 {
+			b.Fatal("no quorum")
+		}
+	}
+}
+
+func benchmarkBlockingSet(b *testing.B, orgs, size int, bitsets bool) {
+	_, q, msgs := orgsNetwork(orgs, size)
+	pred := everyNth(msgs, 5)
+	x := newNodeIndex()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var got NodeIDSet
+		if bitsets {
+			got, _ = q.findBlockingSetIn(x, msgs, pred)
+		} else {
+			got, _ = findBlockingSetSlices(len(q.M)-q.T+1, q.M, msgs, pred, nil)
+		}
+		if len(got) == 0 {
+			b.Fatal("no blocking set")
+		}
+	}
+}
+
+func BenchmarkFindQuorum100Slices(b *testing.B)       { benchmarkQuorum(b, 25, 4, false) }
+func BenchmarkFindQuorum100Bitsets(b *testing.B)      { benchmarkQuorum(b, 25, 4, true) }
+func BenchmarkFindQuorum300Slices(b *testing.B)       { benchmarkQuorum(b, 60, 5, false) }
+func BenchmarkFindQuorum300Bitsets(b *testing.B)      { benchmarkQuorum(b, 60, 5, true) }
+func BenchmarkFindBlockingSet100Slices(b *testing.B)  { benchmarkBlockingSet(b, 25, 4, false) }
+func BenchmarkFindBlockingSet100Bitsets(b *testing.B) { benchmarkBlockingSet(b, 25, 4, true) }
+func BenchmarkFindBlockingSet300Slices(b *testing.B)  { benchmarkBlockingSet(b, 60, 5, false) }
+func BenchmarkFindBlockingSet300Bitsets(b *testing.B) { benchmarkBlockingSet(b, 60, 5, true) }
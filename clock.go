@@ -0,0 +1,31 @@
+package scp
+
+import "time"
+
+// Clock is a node's source of time. It determines when slots are
+// created, when nomination rounds begin, and when deferred updates
+// fire. The default, RealClock, uses wall-clock time. A simulator can
+// substitute virtual time.
+type Clock interface {
+	// Now tells the current time.
+	Now() time.Time
+
+	// AfterFunc arranges for f to be called after duration d.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a pending call scheduled with Clock.AfterFunc.
+type Timer interface {
+	// Stop prevents the call from happening, if it hasn't already.
+	// It tells whether the call was stopped.
+	Stop() bool
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer { return time.AfterFunc(d, f) }
+
+// RealClock is a Clock using wall-clock time.
+var RealClock Clock = realClock{}
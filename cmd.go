@@ -21,56 +21,74 @@ type newRoundCmd struct {
 	slot *Slot
 }
 
+type bumpBNCmd struct {
+	slot *Slot
+}
+
 type rehandleCmd struct {
 	slot *Slot
 }
 
-type delayCmd struct {
-	ms int
+type setQSetCmd struct {
+	q    QSet
+	from SlotID
+}
+
+type exportStateCmd struct {
+	result chan<- exportResult
+}
+
+type importStateCmd struct {
+	state *nodeState
 }
 
 // Internal channel for queueing and processing commands.
 
 type cmdChan struct {
-	cond sync.Cond
-	cmds []Cmd
+	mu     sync.Mutex
+	cmds   []Cmd
+	signal chan struct{} // receives a value (if there's room) on each write
 }
 
 func newCmdChan() *cmdChan {
-	result := new(cmdChan)
-	result.cond.L = new(sync.Mutex)
-	return result
+	return &cmdChan{signal: make(chan struct{}, 1)}
 }
 
 func (c *cmdChan) write(cmd Cmd) {
-	c.cond.L.Lock()
+	c.mu.Lock()
 	c.cmds = append(c.cmds, cmd)
-	c.cond.Broadcast()
-	c.cond.L.Unlock()
+	c.mu.Unlock()
+
+	select {
+	case c.signal <- struct{}{}:
+	default:
+	}
 }
 
-func (c *cmdChan) read(ctx context.Context) (Cmd, bool) {
-	c.cond.L.Lock()
-	defer c.cond.L.Unlock()
+// Returns the next command without waiting, if there is one.
+func (c *cmdChan) poll() (Cmd, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	for len(c.cmds) == 0 {
-		ch := make(chan struct{})
-		go func() {
-			c.cond.Wait()
-			close(ch)
-		}()
+	if len(c.cmds) == 0 {
+		return nil, false
+	}
+	result := c.cmds[0]
+	c.cmds = c.cmds[1:]
+	return result, true
+}
 
+// Returns the next command, waiting for one if necessary. The boolean
+// result is false if ctx is canceled first.
+func (c *cmdChan) read(ctx context.Context) (Cmd, bool) {
+	for {
+		if cmd, ok := c.poll(); ok {
+			return cmd, true
+		}
 		select {
 		case <-ctx.Done():
 			return nil, false
-
-		case <-ch:
-			if len(c.cmds) == 0 {
-				continue
-			}
+		case <-c.signal:
 		}
 	}
-	result := c.cmds[0]
-	c.cmds = c.cmds[1:]
-	return result, true
 }
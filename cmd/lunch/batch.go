@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The outcome of one run in a batch.
+type batchRun struct {
+	seed       int64
+	res        *runResult
+	err        error
+	slowest    time.Duration
+	maxBN      int
+	violations int
+}
+
+// Runs the network described by conf n times in simulation (see
+// runSim), with seeds opts.seed through opts.seed+n-1, at most
+// parallel at a time, and reports on the distribution of results.
+// Since each run is deterministic and keeps virtual time, running
+// them in parallel does not affect the results, and any run can be
+// replayed from its seed. Source is the config file name or -topology
+// option, for replay instructions.
+func runBatch(conf *config, opts runOpts, n, parallel int, source string) {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	// The nodes' (and each run's) log output would be an unreadable
+	// interleaving. Only the report is written, to stdout.
+	log.SetOutput(ioutil.Discard)
+
+	var (
+		runs = make([]*batchRun, n)
+		sem  = make(chan struct{}, parallel)
+		wg   sync.WaitGroup
+	)
+	for i := 0; i < n; i++ {
+		i := i
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			o := opts
+			o.seed = opts.seed + int64(i)
+			br := &batchRun{seed: o.seed}
+			br.res, br.err = runSim(conf, o)
+			if br.err == nil {
+				for _, ss := range br.res.stats.done {
+					if ss.Duration > br.slowest {
+						br.slowest = ss.Duration
+					}
+					if ss.MaxBN > br.maxBN {
+						br.maxBN = ss.MaxBN
+					}
+				}
+				br.violations = len(br.res.violations)
+			}
+			runs[i] = br
+		}()
+	}
+	wg.Wait()
+
+	log.SetOutput(os.Stderr)
+
+	var (
+		latencies []float64 // per slot, in milliseconds
+		bns       []float64 // per slot
+		stalled   int
+	)
+	for _, br := range runs {
+		if br.err != nil {
+			log.Fatalf("seed %d: %s", br.seed, br.err)
+		}
+		if br.res.stalled {
+			stalled++
+		}
+		for _, ss := range br.res.stats.done {
+			latencies = append(latencies, float64(ss.Duration)/float64(time.Millisecond))
+			bns = append(bns, float64(ss.MaxBN))
+		}
+	}
+
+	fmt.Printf("%d runs of %d slots, %d stalled (%.1f%%)\n", n, opts.slots, stalled, 100*float64(stalled)/float64(n))
+	fmt.Printf("slot latency (ms): %s\n", summarize(latencies))
+	fmt.Printf("max ballot counter: %s\n", summarize(bns))
+
+	latFence := fence(latencies)
+	bnFence := fence(bns)
+	var outliers []string
+	for _, br := range runs {
+		if why := br.outlier(latFence, bnFence); len(why) > 0 {
+			outliers = append(outliers, fmt.Sprintf("  seed %d: %s", br.seed, strings.Join(why, "; ")))
+		}
+	}
+	if len(outliers) == 0 {
+		fmt.Println("no outliers")
+		return
+	}
+	fmt.Printf("outliers (replay each with lunch -sim -seed SEED -slots %d -delay %d -combine %s -stall %s %s):\n", opts.slots, opts.delay/time.Millisecond, opts.combine, opts.stall, source)
+	for _, s := range outliers {
+		fmt.Println(s)
+	}
+}
+
+// Tells why a run is an outlier, if it is one. Outliers are runs that
+// stalled, saw invariant violations, or had a slot far slower (or a
+// ballot counter far higher) than is typical, by Tukey's rule: beyond
+// the given fences (see fence) on slot latency in milliseconds and on
+// ballot counters.
+func (br *batchRun) outlier(latFence, bnFence float64) []string {
+	var why []string
+	if br.res.stalled {
+		why = append(why, fmt.Sprintf("stalled after %d slots", len(br.res.stats.done)))
+	}
+	if br.violations > 0 {
+		why = append(why, fmt.Sprintf("%d invariant violations, the first: %s", br.violations, br.res.violations[0]))
+	}
+	if ms := float64(br.slowest) / float64(time.Millisecond); ms > latFence {
+		why = append(why, fmt.Sprintf("slowest slot %s", br.slowest.Round(time.Millisecond)))
+	}
+	if float64(br.maxBN) > bnFence {
+		why = append(why, fmt.Sprintf("ballot counter %d", br.maxBN))
+	}
+	return why
+}
+
+// Describes the distribution of a sample.
+func summarize(xs []float64) string {
+	if len(xs) == 0 {
+		return "no data"
+	}
+	sorted := append([]float64(nil), xs...)
+	sort.Float64s(sorted)
+	var sum float64
+	for _, x := range sorted {
+		sum += x
+	}
+	return fmt.Sprintf("min %.0f, p50 %.0f, p90 %.0f, p99 %.0f, max %.0f, mean %.1f",
+		sorted[0], quantile(sorted, .5), quantile(sorted, .9), quantile(sorted, .99), sorted[len(sorted)-1], sum/float64(len(sorted)))
+}
+
+// Tells the q'th quantile of a sorted sample, using the nearest-rank
+// method.
+func quantile(sorted []float64, q float64) float64 {
+	i := int(q*float64(len(sorted))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+// Tells the value above which a member of the sample is an outlier:
+// the third quartile plus three times the interquartile range.
+func fence(xs []float64) float64 {
+	if len(xs) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), xs...)
+	sort.Float64s(sorted)
+	q1, q3 := quantile(sorted, .25), quantile(sorted, .75)
+	return q3 + 3*(q3-q1)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bobg/scp"
+)
+
+func TestFence(t *testing.T) {
+	cases := []struct {
+		name string
+		xs   []float64
+		want float64
+	}{
+		{name: "empty", xs: nil, want: 0},
+		{name: "one", xs: []float64{5}, want: 5},
+		{name: "constant", xs: []float64{3, 3, 3, 3}, want: 3},
+		// Q1 is 2 and Q3 is 6 by nearest rank.
+		{name: "spread", xs: []float64{8, 1, 6, 2, 4, 5, 3, 7}, want: 18},
+		// The outlier itself does not move the quartiles (10 and 11).
+		{name: "outlier", xs: []float64{10, 10, 11, 12, 1000, 10, 11, 10}, want: 14},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := fence(tc.xs); got != tc.want {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	cases := []struct {
+		xs   []float64
+		want string
+	}{
+		{xs: nil, want: "no data"},
+		{xs: []float64{7}, want: "min 7, p50 7, p90 7, p99 7, max 7, mean 7.0"},
+		{xs: []float64{4, 1, 3, 2}, want: "min 1, p50 2, p90 4, p99 4, max 4, mean 2.5"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.want, func(t *testing.T) {
+			if got := summarize(tc.xs); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestOutlier(t *testing.T) {
+	done := func(n int) *statsCollector {
+		sc := newStatsCollector(time.Now)
+		for i := 0; i < n; i++ {
+			sc.done = append(sc.done, &slotStats{Slot: scp.SlotID(i + 1)})
+		}
+		return sc
+	}
+	const latFence, bnFence = 100, 5 // milliseconds, ballot counter
+	cases := []struct {
+		name string
+		br   batchRun
+		want []string
+	}{
+		{
+			name: "typical",
+			br:   batchRun{res: &runResult{stats: done(4)}, slowest: 100 * time.Millisecond, maxBN: 5},
+			want: nil,
+		},
+		{
+			name: "slow",
+			br:   batchRun{res: &runResult{stats: done(4)}, slowest: 101 * time.Millisecond, maxBN: 5},
+			want: []string{"slowest slot 101ms"},
+		},
+		{
+			name: "high ballot counter",
+			br:   batchRun{res: &runResult{stats: done(4)}, maxBN: 6},
+			want: []string{"ballot counter 6"},
+		},
+		{
+			name: "stalled",
+			br:   batchRun{res: &runResult{stats: done(2), stalled: true}},
+			want: []string{"stalled after 2 slots"},
+		},
+		{
+			name: "violations",
+			br: batchRun{
+				res: &runResult{
+					stats:      done(4),
+					violations: []*scp.Violation{{Node: "a", Slot: 3, Desc: "bad"}, {Node: "b", Slot: 3, Desc: "worse"}},
+				},
+				violations: 2,
+			},
+			want: []string{"2 invariant violations, the first: node a, slot 3: bad"},
+		},
+		{
+			name: "everything",
+			br: batchRun{
+				res: &runResult{
+					stats:      done(1),
+					stalled:    true,
+					violations: []*scp.Violation{{Node: "a", Slot: 1, Desc: "bad"}},
+				},
+				violations: 1,
+				slowest:    2 * time.Second,
+				maxBN:      40,
+			},
+			want: []string{
+				"stalled after 1 slots",
+				"1 invariant violations, the first: node a, slot 1: bad",
+				"slowest slot 2s",
+				"ballot counter 40",
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.br.outlier(latFence, bnFence); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
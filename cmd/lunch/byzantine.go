@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/bobg/scp"
+)
+
+// A behavior makes a node misbehave by altering the messages it
+// sends. The node itself runs the protocol honestly; only what its
+// peers see is affected.
+type behavior interface {
+	// Alter produces the message to deliver to the given recipient in
+	// place of msg, or nil to send nothing.
+	alter(msg *scp.Msg, to scp.NodeID) *scp.Msg
+}
+
+// Names of the behaviors that can appear in a nodeconf.
+const (
+	behaviorHonest     = ""
+	behaviorSilent     = "silent"
+	behaviorEquivocate = "equivocate"
+	behaviorFixed      = "fixed"
+	behaviorInflate    = "inflate"
+)
+
+// Produces the behavior described by a node's config, or nil for an
+// honest node. Values the node makes up are built with the given
+// strategy.
+func newBehavior(nconf nodeconf, peers scp.NodeIDSet, combine strategy) (behavior, error) {
+	switch nconf.Behavior {
+	case behaviorHonest:
+		return nil, nil
+
+	case behaviorSilent:
+		return silent{}, nil
+
+	case behaviorEquivocate:
+		return equivocator{peers: peers, combine: combine}, nil
+
+	case behaviorFixed:
+		food := valType(nconf.Value)
+		if food.IsNil() {
+			food = foods[0]
+		}
+		return fixedValue{food: food, combine: combine}, nil
+
+	case behaviorInflate:
+		by := nconf.Inflate
+		if by <= 0 {
+			by = 1000
+		}
+		return inflator{by: by}, nil
+	}
+	return nil, fmt.Errorf("unknown behavior %q", nconf.Behavior)
+}
+
+// A silent node never sends anything.
+type silent struct{}
+
+// Tells whether the given node is configured to be silent. Since no
+// one hears from a silent node, no one waits for it to externalize.
+func (conf *config) silent(nodeID scp.NodeID) bool {
+	return conf.nodes[string(nodeID)].Behavior == behaviorSilent
+}
+
+func (silent) alter(*scp.Msg, scp.NodeID) *scp.Msg {
+	return nil
+}
+
+// An equivocator nominates a different value to each peer.
+type equivocator struct {
+	peers   scp.NodeIDSet
+	combine strategy
+}
+
+func (e equivocator) alter(msg *scp.Msg, to scp.NodeID) *scp.Msg {
+	// Choose a value that depends on the recipient and the slot.
+	var i int
+	for i < len(e.peers) && e.peers[i] != to {
+		i++
+	}
+	v := e.combine.value(msg.V, []valType{foods[(i+int(msg.I))%len(foods)]})
+
+	switch topic := msg.T.(type) {
+	case *scp.NomTopic:
+		return scp.NewMsg(msg.V, msg.I, msg.Q, &scp.NomTopic{
+			X: scp.ValueSet{v},
+			Y: topic.Y.Remove(v),
+		})
+
+	case *scp.NomPrepTopic:
+		return scp.NewMsg(msg.V, msg.I, msg.Q, &scp.NomPrepTopic{
+			NomTopic: scp.NomTopic{
+				X: scp.ValueSet{v},
+				Y: topic.Y.Remove(v),
+			},
+			PrepTopic: topic.PrepTopic,
+		})
+	}
+	return msg
+}
+
+// A fixedValue node votes for the same food in every message.
+type fixedValue struct {
+	food    valType
+	combine strategy
+}
+
+func (f fixedValue) alter(msg *scp.Msg, _ scp.NodeID) *scp.Msg {
+	v := f.combine.value(msg.V, []valType{f.food})
+	nom := func(topic scp.NomTopic) scp.NomTopic {
+		// X and Y must not intersect.
+		if len(topic.Y) > 0 {
+			return scp.NomTopic{Y: scp.ValueSet{v}}
+		}
+		return scp.NomTopic{X: scp.ValueSet{v}}
+	}
+	ballot := func(b scp.Ballot) scp.Ballot {
+		if b.IsZero() {
+			return b
+		}
+		return scp.Ballot{N: b.N, X: v}
+	}
+	prep := func(topic scp.PrepTopic) scp.PrepTopic {
+		return scp.PrepTopic{
+			B:  ballot(topic.B),
+			P:  ballot(topic.P),
+			HN: topic.HN,
+			CN: topic.CN,
+			// PP must have a different value from P, so omit it.
+		}
+	}
+
+	var t scp.Topic
+	switch topic := msg.T.(type) {
+	case *scp.NomTopic:
+		nt := nom(*topic)
+		t = &nt
+
+	case *scp.NomPrepTopic:
+		t = &scp.NomPrepTopic{
+			NomTopic:  nom(topic.NomTopic),
+			PrepTopic: prep(topic.PrepTopic),
+		}
+
+	case *scp.PrepTopic:
+		pt := prep(*topic)
+		t = &pt
+
+	case *scp.CommitTopic:
+		t = &scp.CommitTopic{B: ballot(topic.B), PN: topic.PN, HN: topic.HN, CN: topic.CN}
+
+	case *scp.ExtTopic:
+		t = &scp.ExtTopic{C: ballot(topic.C), HN: topic.HN}
+
+	default:
+		return msg
+	}
+	return scp.NewMsg(msg.V, msg.I, msg.Q, t)
+}
+
+// An inflator reports ballot counters much higher than its own.
+type inflator struct {
+	by int
+}
+
+func (inf inflator) alter(msg *scp.Msg, _ scp.NodeID) *scp.Msg {
+	var t scp.Topic
+	switch topic := msg.T.(type) {
+	case *scp.NomPrepTopic:
+		nt := *topic
+		nt.B.N += inf.by
+		t = &nt
+
+	case *scp.PrepTopic:
+		pt := *topic
+		pt.B.N += inf.by
+		t = &pt
+
+	case *scp.CommitTopic:
+		ct := *topic
+		ct.B.N += inf.by
+		t = &ct
+
+	default:
+		return msg
+	}
+	return scp.NewMsg(msg.V, msg.I, msg.Q, t)
+}
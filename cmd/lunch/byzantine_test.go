@@ -0,0 +1,143 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bobg/scp"
+)
+
+func TestNewBehavior(t *testing.T) {
+	peers := ids("a b c")
+	cases := []struct {
+		name    string
+		nconf   nodeconf
+		want    behavior
+		wantErr bool
+	}{
+		{name: "honest", nconf: nodeconf{}, want: nil},
+		{name: "silent", nconf: nodeconf{Behavior: "silent"}, want: silent{}},
+		{
+			name:  "equivocate",
+			nconf: nodeconf{Behavior: "equivocate"},
+			want:  equivocator{peers: peers, combine: combineParity},
+		},
+		{
+			name:  "fixed",
+			nconf: nodeconf{Behavior: "fixed", Value: "soup"},
+			want:  fixedValue{food: "soup", combine: combineParity},
+		},
+		{
+			name:  "fixed default",
+			nconf: nodeconf{Behavior: "fixed"},
+			want:  fixedValue{food: foods[0], combine: combineParity},
+		},
+		{name: "inflate", nconf: nodeconf{Behavior: "inflate", Inflate: 7}, want: inflator{by: 7}},
+		{name: "inflate default", nconf: nodeconf{Behavior: "inflate"}, want: inflator{by: 1000}},
+		{name: "inflate negative", nconf: nodeconf{Behavior: "inflate", Inflate: -3}, want: inflator{by: 1000}},
+		{name: "unknown", nconf: nodeconf{Behavior: "sneaky"}, wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := newBehavior(tc.nconf, peers, combineParity)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("got %#v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %#v, want %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestBehaviorAlter(t *testing.T) {
+	qset, err := parseTestQSet("2(b c)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nom := &scp.NomTopic{X: scp.ValueSet{valType("pizza")}}
+	prep := &scp.PrepTopic{B: scp.Ballot{N: 2, X: valType("pizza")}, HN: 1}
+	commit := &scp.CommitTopic{B: scp.Ballot{N: 3, X: valType("pizza")}, PN: 3, HN: 3, CN: 2}
+
+	cases := []struct {
+		name  string
+		b     behavior
+		topic scp.Topic
+		to    scp.NodeID
+		want  scp.Topic // nil for no message
+	}{
+		{name: "silent", b: silent{}, topic: nom, to: "b", want: nil},
+		{
+			name:  "equivocate to b",
+			b:     equivocator{peers: ids("a b c"), combine: combineParity},
+			topic: nom,
+			to:    "b",
+			want:  &scp.NomTopic{X: scp.ValueSet{foods[2]}}, // peer 1 in slot 1
+		},
+		{
+			name:  "equivocate to c",
+			b:     equivocator{peers: ids("a b c"), combine: combineParity},
+			topic: nom,
+			to:    "c",
+			want:  &scp.NomTopic{X: scp.ValueSet{foods[3]}},
+		},
+		{
+			name:  "equivocate leaves ballots alone",
+			b:     equivocator{peers: ids("a b c"), combine: combineParity},
+			topic: prep,
+			to:    "b",
+			want:  prep,
+		},
+		{
+			name:  "fixed nomination",
+			b:     fixedValue{food: "soup", combine: combineParity},
+			topic: nom,
+			to:    "b",
+			want:  &scp.NomTopic{X: scp.ValueSet{valType("soup")}},
+		},
+		{
+			name:  "fixed commit",
+			b:     fixedValue{food: "soup", combine: combineParity},
+			topic: commit,
+			to:    "b",
+			want:  &scp.CommitTopic{B: scp.Ballot{N: 3, X: valType("soup")}, PN: 3, HN: 3, CN: 2},
+		},
+		{
+			name:  "inflate prepare",
+			b:     inflator{by: 10},
+			topic: prep,
+			to:    "b",
+			want:  &scp.PrepTopic{B: scp.Ballot{N: 12, X: valType("pizza")}, HN: 1},
+		},
+		{
+			name:  "inflate leaves nominations alone",
+			b:     inflator{by: 10},
+			topic: nom,
+			to:    "b",
+			want:  nom,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := tc.b.alter(scp.NewMsg("a", 1, qset, tc.topic), tc.to)
+			if tc.want == nil {
+				if got != nil {
+					t.Errorf("got %s, want no message", got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatalf("got no message, want %s", tc.want)
+			}
+			if got.T.String() != tc.want.String() {
+				t.Errorf("got %s, want %s", got.T, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/bobg/scp"
+)
+
+// A crashconf describes a scheduled node crash, read from a [[crash]]
+// table in the config file:
+//
+//	[[crash]]
+//	node = "bob"
+//	at = "5s"        # when bob crashes
+//	restart = "12s"  # when bob comes back (default: never)
+//	keep = "ext"     # what bob remembers when it does: "ext" or "state"
+//
+// A crashed node processes nothing and receives no messages; messages
+// sent to it are lost. With keep = "ext" (the default) the node
+// restarts with only its record of externalized values, as though
+// that were all it had persisted, and begins nominating again for the
+// slot it was working on. With keep = "state" it resumes with all its
+// slot state intact.
+type crashconf struct {
+	Node        string
+	At, Restart duration
+	Keep        string
+}
+
+const (
+	keepExt   = "ext"
+	keepState = "state"
+)
+
+func (cc crashconf) validate(conf *config) error {
+	if _, ok := conf.nodes[cc.Node]; !ok {
+		return fmt.Errorf("crash: unknown node %q", cc.Node)
+	}
+	if cc.Restart.Duration > 0 && cc.Restart.Duration <= cc.At.Duration {
+		return fmt.Errorf("crash of %s: restart must come after the crash", cc.Node)
+	}
+	switch cc.Keep {
+	case "", keepExt, keepState:
+	default:
+		return fmt.Errorf("crash of %s: keep must be %q or %q", cc.Node, keepExt, keepState)
+	}
+	return nil
+}
+
+// A discard is the fault.Handler for a crashed node.
+type discard struct{}
+
+func (discard) Handle(*scp.Msg) {}
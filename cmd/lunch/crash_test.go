@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCrashValidate(t *testing.T) {
+	conf := testConf(t, "a:1(b) b:1(a)")
+	cases := []struct {
+		name    string
+		cc      crashconf
+		wantErr bool
+	}{
+		{name: "crash only", cc: crashconf{Node: "a", At: duration{time.Second}}},
+		{name: "restart", cc: crashconf{Node: "a", At: duration{time.Second}, Restart: duration{2 * time.Second}}},
+		{name: "keep ext", cc: crashconf{Node: "a", Restart: duration{time.Second}, Keep: "ext"}},
+		{name: "keep state", cc: crashconf{Node: "a", Restart: duration{time.Second}, Keep: "state"}},
+		{name: "unknown node", cc: crashconf{Node: "z"}, wantErr: true},
+		{name: "restart too soon", cc: crashconf{Node: "a", At: duration{time.Second}, Restart: duration{time.Second}}, wantErr: true},
+		{name: "unknown keep", cc: crashconf{Node: "a", Keep: "everything"}, wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.cc.validate(conf)
+			if tc.wantErr && err == nil {
+				t.Error("got no error")
+			} else if !tc.wantErr && err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestCrashRestart(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs in real time")
+	}
+
+	// Each node needs two of the other three, so the rest carry on
+	// while one is down. Once it is back, a slot is complete only when
+	// it too has externalized it, so finishing the run shows that the
+	// restarted node caught up.
+	const topology = "a:2(b c d) b:2(a c d) c:2(a b d) d:2(a b c)"
+	cases := []struct {
+		name string
+		cc   crashconf
+	}{
+		{name: "keep ext", cc: crashconf{Node: "a", At: duration{50 * time.Millisecond}, Restart: duration{300 * time.Millisecond}, Keep: keepExt}},
+		{name: "keep state", cc: crashconf{Node: "a", At: duration{50 * time.Millisecond}, Restart: duration{300 * time.Millisecond}, Keep: keepState}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			conf := testConf(t, topology)
+			conf.crashes = []crashconf{tc.cc}
+			res, err := run(conf, runOpts{seed: 1, delay: 50 * time.Millisecond, slots: 6, stall: 10 * time.Second, combine: combineParity})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.stalled {
+				t.Errorf("stalled after %d slots", len(res.stats.done))
+			}
+			if len(res.violations) > 0 {
+				t.Errorf("got %d violations, the first: %s", len(res.violations), res.violations[0])
+			}
+		})
+	}
+}
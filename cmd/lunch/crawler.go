@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+
+	"github.com/bobg/scp"
+)
+
+// A crawlerNode is one node in the JSON produced by network crawlers:
+//
+//	[
+//	  {
+//	    "publicKey": "GABC...",
+//	    "name": "sdf1",
+//	    "quorumSet": {
+//	      "threshold": 2,
+//	      "validators": ["GDEF...", "GHIJ..."],
+//	      "innerQuorumSets": [{"threshold": 1, "validators": [...]}]
+//	    }
+//	  },
+//	  ...
+//	]
+//
+// The list may also appear as the "nodes" field of an object.
+type crawlerNode struct {
+	PublicKey string       `json:"publicKey"`
+	Name      string       `json:"name"`
+	QuorumSet *crawlerQSet `json:"quorumSet"`
+}
+
+type crawlerQSet struct {
+	Threshold       int           `json:"threshold"`
+	Validators      []string      `json:"validators"`
+	InnerQuorumSets []crawlerQSet `json:"innerQuorumSets"`
+}
+
+func (cq *crawlerQSet) empty() bool {
+	return cq == nil || cq.Threshold == 0 || len(cq.Validators)+len(cq.InnerQuorumSets) == 0
+}
+
+// Reads a network from a crawler's JSON file. Nodes are named by their
+// "name" fields where those are present and unique, and otherwise by a
+// prefix of their public keys. Nodes without a quorum set (watchers)
+// are omitted. Validators that appear in quorum sets but not in the
+// file, or without quorum sets of their own, are included as silent
+// nodes, since nothing is known of how they would vote. So are
+// validators whose quorum sets name only themselves.
+func readCrawlerJSON(filename string) (*config, error) {
+	bits, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var nodes []crawlerNode
+	if err = json.Unmarshal(bits, &nodes); err != nil {
+		var wrapped struct {
+			Nodes []crawlerNode `json:"nodes"`
+		}
+		if err2 := json.Unmarshal(bits, &wrapped); err2 != nil {
+			return nil, err
+		}
+		nodes = wrapped.Nodes
+	}
+
+	byKey := make(map[string]crawlerNode)
+	for _, n := range nodes {
+		if n.PublicKey == "" {
+			return nil, fmt.Errorf("node %q has no public key", n.Name)
+		}
+		byKey[n.PublicKey] = n
+	}
+
+	// Find the nodes to include: every node with a quorum set, and
+	// every validator named in one.
+	included := make(map[string]bool)
+	var visit func(cq *crawlerQSet)
+	visit = func(cq *crawlerQSet) {
+		for _, v := range cq.Validators {
+			included[v] = true
+		}
+		for i := range cq.InnerQuorumSets {
+			visit(&cq.InnerQuorumSets[i])
+		}
+	}
+	for key, n := range byKey {
+		if !n.QuorumSet.empty() {
+			included[key] = true
+			visit(n.QuorumSet)
+		}
+	}
+	var keys []string
+	for key := range included {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	names := crawlerNames(keys, byKey)
+
+	conf := &config{nodes: make(map[string]nodeconf)}
+	var silent []string
+	for _, key := range keys {
+		n := byKey[key]
+		name := names[key]
+		var q scp.QSet
+		if !n.QuorumSet.empty() {
+			q = n.QuorumSet.qset(key, names)
+		}
+		if len(q.M) == 0 {
+			// Either nothing is known of the node's quorum set, or it
+			// trusts only itself, which SCP cannot express.
+			conf.nodes[name] = nodeconf{Behavior: behaviorSilent}
+			silent = append(silent, name)
+			continue
+		}
+		conf.nodes[name] = nodeconf{Q: q}
+	}
+	if len(silent) > 0 {
+		sort.Strings(silent)
+		log.Printf("%d validators with no usable quorum set will be silent: %v", len(silent), silent)
+	}
+
+	err = conf.validate()
+	if err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// Chooses a unique name for each public key.
+func crawlerNames(keys []string, byKey map[string]crawlerNode) map[string]string {
+	count := make(map[string]int)
+	for _, key := range keys {
+		if name := byKey[key].Name; name != "" {
+			count[name]++
+		}
+	}
+	result := make(map[string]string)
+	used := make(map[string]bool)
+	for _, key := range keys {
+		name := byKey[key].Name
+		if name == "" || count[name] > 1 {
+			name = key
+			if len(key) > 8 {
+				name = key[:8]
+			}
+			if used[name] || count[name] > 0 {
+				name = key
+			}
+		}
+		result[key] = name
+		used[name] = true
+	}
+	return result
+}
+
+// Converts a crawler quorum set, for the node with the given key, to
+// an scp.QSet. The node itself is implicit in every slice of an
+// scp.QSet, so where it appears as a validator it is removed and the
+// threshold lowered to match.
+func (cq crawlerQSet) qset(self string, names map[string]string) scp.QSet {
+	q := scp.QSet{T: cq.Threshold}
+	for _, v := range cq.Validators {
+		if v == self {
+			q.T--
+			continue
+		}
+		id := scp.NodeID(names[v])
+		q.M = append(q.M, scp.QSetMember{N: &id})
+	}
+	for _, inner := range cq.InnerQuorumSets {
+		if inner.empty() {
+			continue
+		}
+		innerQ := inner.qset(self, names)
+		if innerQ.T <= 0 {
+			// Satisfied by the node itself.
+			q.T--
+			continue
+		}
+		q.M = append(q.M, scp.QSetMember{Q: &innerQ})
+	}
+	if q.T <= 0 {
+		return scp.QSet{}
+	}
+	return q
+}
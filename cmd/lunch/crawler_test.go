@@ -0,0 +1,148 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestCrawlerNames(t *testing.T) {
+	cases := []struct {
+		name  string
+		nodes []crawlerNode
+		want  map[string]string
+	}{
+		{
+			name:  "unique names",
+			nodes: []crawlerNode{{PublicKey: "GAAAAAAAAAAA", Name: "alice"}, {PublicKey: "GBBBBBBBBBBB", Name: "bob"}},
+			want:  map[string]string{"GAAAAAAAAAAA": "alice", "GBBBBBBBBBBB": "bob"},
+		},
+		{
+			name:  "no name",
+			nodes: []crawlerNode{{PublicKey: "GAAAAAAAAAAA"}, {PublicKey: "GB"}},
+			want:  map[string]string{"GAAAAAAAAAAA": "GAAAAAAA", "GB": "GB"},
+		},
+		{
+			name:  "duplicate names",
+			nodes: []crawlerNode{{PublicKey: "GAAAAAAAAAAA", Name: "sdf"}, {PublicKey: "GBBBBBBBBBBB", Name: "sdf"}, {PublicKey: "GCCCCCCCCCCC", Name: "carol"}},
+			want:  map[string]string{"GAAAAAAAAAAA": "GAAAAAAA", "GBBBBBBBBBBB": "GBBBBBBB", "GCCCCCCCCCCC": "carol"},
+		},
+		{
+			name:  "shared prefix",
+			nodes: []crawlerNode{{PublicKey: "GAAAAAAAAAAA"}, {PublicKey: "GAAAAAAABBBB"}},
+			want:  map[string]string{"GAAAAAAAAAAA": "GAAAAAAA", "GAAAAAAABBBB": "GAAAAAAABBBB"},
+		},
+		{
+			// Another node's name takes the prefix.
+			name:  "prefix is a name",
+			nodes: []crawlerNode{{PublicKey: "GAAAAAAAAAAA"}, {PublicKey: "GBBBBBBBBBBB", Name: "GAAAAAAA"}},
+			want:  map[string]string{"GAAAAAAAAAAA": "GAAAAAAAAAAA", "GBBBBBBBBBBB": "GAAAAAAA"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var keys []string
+			byKey := make(map[string]crawlerNode)
+			for _, n := range tc.nodes {
+				keys = append(keys, n.PublicKey)
+				byKey[n.PublicKey] = n
+			}
+			if got := crawlerNames(keys, byKey); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCrawlerQSet(t *testing.T) {
+	names := map[string]string{"KS": "self", "KA": "a", "KB": "b", "KC": "c"}
+	cases := []struct {
+		name string
+		cq   crawlerQSet
+		want string // in TOML form
+	}{
+		{
+			name: "flat",
+			cq:   crawlerQSet{Threshold: 2, Validators: []string{"KA", "KB", "KC"}},
+			want: `{t = 2, m = [{n = "a"}, {n = "b"}, {n = "c"}]}`,
+		},
+		{
+			name: "self",
+			cq:   crawlerQSet{Threshold: 2, Validators: []string{"KS", "KA", "KB"}},
+			want: `{t = 1, m = [{n = "a"}, {n = "b"}]}`,
+		},
+		{
+			name: "only self",
+			cq:   crawlerQSet{Threshold: 1, Validators: []string{"KS", "KA"}},
+			want: `{t = 0, m = []}`,
+		},
+		{
+			name: "nested",
+			cq: crawlerQSet{Threshold: 2, Validators: []string{"KA"}, InnerQuorumSets: []crawlerQSet{
+				{Threshold: 1, Validators: []string{"KB", "KC"}},
+			}},
+			want: `{t = 2, m = [{n = "a"}, {q = {t = 1, m = [{n = "b"}, {n = "c"}]}}]}`,
+		},
+		{
+			name: "self satisfies inner",
+			cq: crawlerQSet{Threshold: 2, Validators: []string{"KA"}, InnerQuorumSets: []crawlerQSet{
+				{Threshold: 1, Validators: []string{"KS", "KB"}},
+			}},
+			want: `{t = 1, m = [{n = "a"}]}`,
+		},
+		{
+			name: "self in inner",
+			cq: crawlerQSet{Threshold: 1, InnerQuorumSets: []crawlerQSet{
+				{Threshold: 2, Validators: []string{"KS", "KB", "KC"}},
+			}},
+			want: `{t = 1, m = [{q = {t = 1, m = [{n = "b"}, {n = "c"}]}}]}`,
+		},
+		{
+			name: "empty inner",
+			cq: crawlerQSet{Threshold: 1, Validators: []string{"KA"}, InnerQuorumSets: []crawlerQSet{
+				{Threshold: 1},
+			}},
+			want: `{t = 1, m = [{n = "a"}]}`,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tomlQSet(tc.cq.qset("KS", names)); got != tc.want {
+				t.Errorf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestReadCrawlerJSON(t *testing.T) {
+	const data = `{"nodes": [
+		{"publicKey": "GAAAAAAAAAAA", "name": "alice", "quorumSet": {"threshold": 2, "validators": ["GAAAAAAAAAAA", "GBBBBBBBBBBB", "GCCCCCCCCCCC"]}},
+		{"publicKey": "GBBBBBBBBBBB", "name": "bob", "quorumSet": {"threshold": 1, "validators": ["GAAAAAAAAAAA"]}},
+		{"publicKey": "GWWWWWWWWWWW", "name": "watcher"}
+	]}`
+	filename := filepath.Join(t.TempDir(), "crawl.json")
+	if err := ioutil.WriteFile(filename, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := readCrawlerJSON(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for name := range conf.nodes {
+		got = append(got, name)
+	}
+	sort.Strings(got)
+	if want := []string{"GCCCCCCC", "alice", "bob"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got nodes %v, want %v", got, want)
+	}
+	if b := conf.nodes["GCCCCCCC"].Behavior; b != behaviorSilent {
+		t.Errorf("unknown validator has behavior %q, want %q", b, behaviorSilent)
+	}
+	if got, want := tomlQSet(conf.nodes["alice"].Q), `{t = 1, m = [{n = "bob"}, {n = "GCCCCCCC"}]}`; got != want {
+		t.Errorf("alice has QSet %s, want %s", got, want)
+	}
+}
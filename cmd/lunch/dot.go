@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bobg/scp"
+)
+
+// Writes the trust graph of conf in Graphviz DOT form. Each node has
+// an edge to each node in its QSet and is labelled with its
+// threshold. A nested QSet appears as a point, inside a cluster
+// labelled with its threshold, with edges to its own members. Silent
+// nodes are dashed.
+//
+// If highlight names a node, a quorum containing it is filled in
+// blue, and a blocking set for it is outlined in red.
+func (conf *config) writeDOT(w io.Writer, highlight string) error {
+	var names []string
+	for name := range conf.nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var quorum, blocking scp.NodeIDSet
+	if highlight != "" {
+		nconf, ok := conf.nodes[highlight]
+		if !ok {
+			return fmt.Errorf("unknown node %q", highlight)
+		}
+		t := conf.topology()
+		id := scp.NodeID(highlight)
+		quorum = t.minQuorum(id, t.nodeIDs)
+		blocking = blockingSet(nconf.Q)
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph trust {")
+	if highlight != "" {
+		if len(quorum) == 0 {
+			fmt.Fprintf(bw, "  // %s belongs to no quorum\n", highlight)
+		}
+		fmt.Fprintf(bw, "  label=%s;\n", strconv.Quote(fmt.Sprintf("quorum (blue) and blocking set (red) for %s", highlight)))
+	}
+	for _, name := range names {
+		id := scp.NodeID(name)
+		attrs := dotNodeAttrs(name, conf.nodes[name].Q, conf.silent(id), quorum.Contains(id), blocking.Contains(id))
+		fmt.Fprintf(bw, "  %s [%s];\n", strconv.Quote(name), attrs)
+	}
+	var edges []string
+	for _, name := range names {
+		n := 0
+		writeDOTQSet(bw, "  ", name, name, conf.nodes[name].Q, &n, &edges)
+	}
+	for _, e := range edges {
+		fmt.Fprintf(bw, "  %s\n", e)
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+// Produces the DOT attributes of the lunch node with the given name
+// and QSet. A node that is both silent and in the highlighted quorum
+// is dashed and filled.
+func dotNodeAttrs(name string, q scp.QSet, silent, inQuorum, blocking bool) string {
+	attrs := fmt.Sprintf("label=%s", strconv.Quote(fmt.Sprintf("%s\n%s", name, thresholdLabel(q))))
+	var styles []string
+	if silent {
+		styles = append(styles, "dashed")
+	}
+	if inQuorum {
+		styles = append(styles, "filled")
+		attrs += ", fillcolor=lightblue"
+	}
+	if len(styles) > 0 {
+		attrs += fmt.Sprintf(", style=%s", strconv.Quote(strings.Join(styles, ",")))
+	}
+	if blocking {
+		attrs += ", color=red, penwidth=2"
+	}
+	return attrs
+}
+
+func thresholdLabel(q scp.QSet) string {
+	return fmt.Sprintf("%d of %d", q.T, len(q.M))
+}
+
+// Writes the clusters for the nested sets in q, which belongs to the
+// lunch node named owner, and adds the edges from the DOT node named
+// from to the members of q to *edges. Nested sets are numbered using
+// *n. Edges are written outside the clusters, since a node mentioned
+// inside a cluster is drawn there.
+func writeDOTQSet(w io.Writer, indent, owner, from string, q scp.QSet, n *int, edges *[]string) {
+	for _, m := range q.M {
+		if m.N != nil {
+			*edges = append(*edges, fmt.Sprintf("%s -> %s;", strconv.Quote(from), strconv.Quote(string(*m.N))))
+			continue
+		}
+		*n++
+		inner := fmt.Sprintf("%s/%d", owner, *n)
+		fmt.Fprintf(w, "%ssubgraph %s {\n", indent, strconv.Quote("cluster_"+inner))
+		fmt.Fprintf(w, "%s  label=%s;\n", indent, strconv.Quote(thresholdLabel(*m.Q)))
+		fmt.Fprintf(w, "%s  %s [shape=point];\n", indent, strconv.Quote(inner))
+		writeDOTQSet(w, indent+"  ", owner, inner, *m.Q, n, edges)
+		fmt.Fprintf(w, "%s}\n", indent)
+		*edges = append(*edges, fmt.Sprintf("%s -> %s;", strconv.Quote(from), strconv.Quote(inner)))
+	}
+}
+
+func writeDOTFile(filename string, conf *config, highlight string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	err = conf.writeDOT(f, highlight)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
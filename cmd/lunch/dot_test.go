@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/bobg/scp"
+)
+
+func TestDOTNodeAttrs(t *testing.T) {
+	b := scp.NodeID("b")
+	q := scp.QSet{T: 1, M: []scp.QSetMember{{N: &b}}}
+	const label = `label="a\n1 of 1"`
+
+	cases := []struct {
+		name                       string
+		silent, inQuorum, blocking bool
+		want                       string
+	}{
+		{name: "plain", want: label},
+		{name: "silent", silent: true, want: label + `, style="dashed"`},
+		{name: "quorum", inQuorum: true, want: label + `, fillcolor=lightblue, style="filled"`},
+		{name: "blocking", blocking: true, want: label + `, color=red, penwidth=2`},
+		{name: "silent quorum", silent: true, inQuorum: true, want: label + `, fillcolor=lightblue, style="dashed,filled"`},
+		{name: "silent blocking", silent: true, blocking: true, want: label + `, style="dashed", color=red, penwidth=2`},
+		{name: "all", silent: true, inQuorum: true, blocking: true, want: label + `, fillcolor=lightblue, style="dashed,filled", color=red, penwidth=2`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := dotNodeAttrs("a", q, tc.silent, tc.inQuorum, tc.blocking); got != tc.want {
+				t.Errorf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWriteDOT(t *testing.T) {
+	conf := testConf(t, "a:2(b 1(c d)) b:1(a) c:1(a) d:0()")
+	conf.nodes["d"] = nodeconf{Behavior: behaviorSilent}
+
+	cases := []struct {
+		highlight string
+		want      string
+	}{
+		{
+			want: `digraph trust {
+  "a" [label="a\n2 of 2"];
+  "b" [label="b\n1 of 1"];
+  "c" [label="c\n1 of 1"];
+  "d" [label="d\n0 of 0", style="dashed"];
+  subgraph "cluster_a/1" {
+    label="1 of 2";
+    "a/1" [shape=point];
+  }
+  "a" -> "b";
+  "a/1" -> "c";
+  "a/1" -> "d";
+  "a" -> "a/1";
+  "b" -> "a";
+  "c" -> "a";
+}
+`,
+		},
+		{
+			highlight: "a",
+			want: `digraph trust {
+  label="quorum (blue) and blocking set (red) for a";
+  "a" [label="a\n2 of 2", fillcolor=lightblue, style="filled"];
+  "b" [label="b\n1 of 1", fillcolor=lightblue, style="filled", color=red, penwidth=2];
+  "c" [label="c\n1 of 1", fillcolor=lightblue, style="filled"];
+  "d" [label="d\n0 of 0", style="dashed"];
+  subgraph "cluster_a/1" {
+    label="1 of 2";
+    "a/1" [shape=point];
+  }
+  "a" -> "b";
+  "a/1" -> "c";
+  "a/1" -> "d";
+  "a" -> "a/1";
+  "b" -> "a";
+  "c" -> "a";
+}
+`,
+		},
+		{
+			highlight: "d",
+			want: `digraph trust {
+  // d belongs to no quorum
+  label="quorum (blue) and blocking set (red) for d";
+  "a" [label="a\n2 of 2"];
+  "b" [label="b\n1 of 1"];
+  "c" [label="c\n1 of 1"];
+  "d" [label="d\n0 of 0", style="dashed"];
+  subgraph "cluster_a/1" {
+    label="1 of 2";
+    "a/1" [shape=point];
+  }
+  "a" -> "b";
+  "a/1" -> "c";
+  "a/1" -> "d";
+  "a" -> "a/1";
+  "b" -> "a";
+  "c" -> "a";
+}
+`,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.highlight, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			if err := conf.writeDOT(buf, tc.highlight); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tc.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+
+	if err := conf.writeDOT(new(bytes.Buffer), "z"); err == nil {
+		t.Error("no error highlighting an unknown node")
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bobg/scp"
+)
+
+// An eventLog writes one JSON object per line for each event in a run
+// (with -format json). A nil *eventLog discards events.
+type eventLog struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	phases map[scp.NodeID]map[scp.SlotID]string // the latest phase of each node in each slot
+}
+
+// Kinds of event.
+const (
+	evSend        = "send"        // a node sent a message
+	evDeliver     = "deliver"     // a message reached its recipient
+	evDrop        = "drop"        // a message was lost on the way to its recipient
+	evPhase       = "phase"       // a node's messages for a slot entered a new phase
+	evExternalize = "externalize" // a node externalized a value for a slot
+)
+
+type event struct {
+	Time  time.Time  `json:"time"`
+	Event string     `json:"event"`
+	Node  scp.NodeID `json:"node"`
+	Slot  scp.SlotID `json:"slot"`
+	To    scp.NodeID `json:"to,omitempty"`
+	Topic *topicJSON `json:"topic,omitempty"`
+	Phase string     `json:"phase,omitempty"`
+	Value string     `json:"value,omitempty"`
+}
+
+type topicJSON struct {
+	Type string      `json:"type"`
+	X    []string    `json:"x,omitempty"`
+	Y    []string    `json:"y,omitempty"`
+	B    *ballotJSON `json:"b,omitempty"`
+	P    *ballotJSON `json:"p,omitempty"`
+	PP   *ballotJSON `json:"pp,omitempty"`
+	C    *ballotJSON `json:"c,omitempty"`
+	PN   int         `json:"pn,omitempty"`
+	HN   int         `json:"hn,omitempty"`
+	CN   int         `json:"cn,omitempty"`
+}
+
+type ballotJSON struct {
+	N int    `json:"n"`
+	X string `json:"x"`
+}
+
+func newEventLog(w io.Writer) *eventLog {
+	return &eventLog{
+		enc:    json.NewEncoder(w),
+		phases: make(map[scp.NodeID]map[scp.SlotID]string),
+	}
+}
+
+// Sent records a message sent by msg.V, along with any resulting
+// phase change or externalization.
+func (e *eventLog) sent(msg *scp.Msg) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	topic := encodeTopic(msg.T)
+	e.write(&event{Event: evSend, Node: msg.V, Slot: msg.I, Topic: topic})
+
+	phases, ok := e.phases[msg.V]
+	if !ok {
+		phases = make(map[scp.SlotID]string)
+		e.phases[msg.V] = phases
+	}
+	if phases[msg.I] == topic.Type {
+		return
+	}
+	phases[msg.I] = topic.Type
+	e.write(&event{Event: evPhase, Node: msg.V, Slot: msg.I, Phase: topic.Type})
+	if t, ok := msg.T.(*scp.ExtTopic); ok {
+		e.write(&event{Event: evExternalize, Node: msg.V, Slot: msg.I, Value: scp.VString(t.C.X)})
+	}
+}
+
+// Delivered records the arrival of a message at a node.
+func (e *eventLog) delivered(to scp.NodeID, msg *scp.Msg) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.write(&event{Event: evDeliver, Node: msg.V, Slot: msg.I, To: to, Topic: encodeTopic(msg.T)})
+}
+
+// Dropped records the loss of a message on its way to a node.
+func (e *eventLog) dropped(to scp.NodeID, msg *scp.Msg) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.write(&event{Event: evDrop, Node: msg.V, Slot: msg.I, To: to, Topic: encodeTopic(msg.T)})
+}
+
+func (e *eventLog) write(ev *event) {
+	ev.Time = time.Now()
+	err := e.enc.Encode(ev)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func encodeTopic(t scp.Topic) *topicJSON {
+	vals := func(vs scp.ValueSet) []string {
+		var result []string
+		for _, v := range vs {
+			result = append(result, scp.VString(v))
+		}
+		return result
+	}
+	ballot := func(b scp.Ballot) *ballotJSON {
+		if b.IsZero() {
+			return nil
+		}
+		return &ballotJSON{N: b.N, X: scp.VString(b.X)}
+	}
+
+	var tj topicJSON
+	prep := func(topic *scp.PrepTopic) {
+		tj.B = ballot(topic.B)
+		tj.P = ballot(topic.P)
+		tj.PP = ballot(topic.PP)
+		tj.HN = topic.HN
+		tj.CN = topic.CN
+	}
+	switch topic := t.(type) {
+	case *scp.NomTopic:
+		tj.Type = "NOM"
+		tj.X = vals(topic.X)
+		tj.Y = vals(topic.Y)
+
+	case *scp.NomPrepTopic:
+		tj.Type = "NOM/PREP"
+		tj.X = vals(topic.X)
+		tj.Y = vals(topic.Y)
+		prep(&topic.PrepTopic)
+
+	case *scp.PrepTopic:
+		tj.Type = "PREP"
+		prep(topic)
+
+	case *scp.CommitTopic:
+		tj.Type = "COMMIT"
+		tj.B = ballot(topic.B)
+		tj.PN = topic.PN
+		tj.HN = topic.HN
+		tj.CN = topic.CN
+
+	case *scp.ExtTopic:
+		tj.Type = "EXT"
+		tj.C = ballot(topic.C)
+		tj.HN = topic.HN
+	}
+	return &tj
+}
+
+// A deliverer is the fault.Handler for a node,
+// recording each delivery before passing it along.
+type deliverer struct {
+	node   *scp.Node
+	events *eventLog
+}
+
+func (d deliverer) Handle(msg *scp.Msg) {
+	d.events.delivered(d.node.ID, msg)
+	d.node.Handle(msg)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/bobg/scp"
+)
+
+func TestEncodeTopic(t *testing.T) {
+	pizza := scp.Ballot{N: 2, X: valType("pizza")}
+	soup := scp.Ballot{N: 1, X: valType("soup")}
+	cases := []struct {
+		name  string
+		topic scp.Topic
+		want  string
+	}{
+		{
+			name:  "nom",
+			topic: &scp.NomTopic{X: scp.ValueSet{valType("pizza")}, Y: scp.ValueSet{valType("soup")}},
+			want:  `{"type":"NOM","x":["pizza"],"y":["soup"]}`,
+		},
+		{
+			name:  "empty nom",
+			topic: &scp.NomTopic{},
+			want:  `{"type":"NOM"}`,
+		},
+		{
+			name: "nom/prep",
+			topic: &scp.NomPrepTopic{
+				NomTopic:  scp.NomTopic{X: scp.ValueSet{valType("pizza")}},
+				PrepTopic: scp.PrepTopic{B: pizza},
+			},
+			want: `{"type":"NOM/PREP","x":["pizza"],"b":{"n":2,"x":"pizza"}}`,
+		},
+		{
+			name:  "prep",
+			topic: &scp.PrepTopic{B: pizza, P: pizza, PP: soup, HN: 2, CN: 1},
+			want:  `{"type":"PREP","b":{"n":2,"x":"pizza"},"p":{"n":2,"x":"pizza"},"pp":{"n":1,"x":"soup"},"hn":2,"cn":1}`,
+		},
+		{
+			name:  "commit",
+			topic: &scp.CommitTopic{B: pizza, PN: 3, HN: 2, CN: 1},
+			want:  `{"type":"COMMIT","b":{"n":2,"x":"pizza"},"pn":3,"hn":2,"cn":1}`,
+		},
+		{
+			name:  "ext",
+			topic: &scp.ExtTopic{C: pizza, HN: 4},
+			want:  `{"type":"EXT","c":{"n":2,"x":"pizza"},"hn":4}`,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := json.Marshal(encodeTopic(tc.topic))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tc.want {
+				t.Errorf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestEventLog(t *testing.T) {
+	var q scp.QSet
+	nom := scp.NewMsg("a", 1, q, &scp.NomTopic{X: scp.ValueSet{valType("pizza")}})
+	nom2 := scp.NewMsg("a", 1, q, &scp.NomTopic{X: scp.ValueSet{valType("pizza"), valType("soup")}})
+	ext := scp.NewMsg("a", 1, q, &scp.ExtTopic{C: scp.Ballot{N: 1, X: valType("pizza")}, HN: 1})
+	bnom := scp.NewMsg("b", 1, q, &scp.NomTopic{X: scp.ValueSet{valType("soup")}})
+
+	cases := []struct {
+		name string
+		f    func(*eventLog)
+		want string // event, node, slot, and to/phase/value of each event
+	}{
+		{
+			name: "first message",
+			f:    func(e *eventLog) { e.sent(nom) },
+			want: "send a 1; phase a 1 NOM",
+		},
+		{
+			name: "same phase",
+			f:    func(e *eventLog) { e.sent(nom); e.sent(nom2) },
+			want: "send a 1; phase a 1 NOM; send a 1",
+		},
+		{
+			name: "phases per node",
+			f:    func(e *eventLog) { e.sent(nom); e.sent(bnom) },
+			want: "send a 1; phase a 1 NOM; send b 1; phase b 1 NOM",
+		},
+		{
+			name: "externalize",
+			f:    func(e *eventLog) { e.sent(nom); e.sent(ext) },
+			want: "send a 1; phase a 1 NOM; send a 1; phase a 1 EXT; externalize a 1 pizza",
+		},
+		{
+			name: "deliver and drop",
+			f:    func(e *eventLog) { e.delivered("b", nom); e.dropped("c", nom) },
+			want: "deliver a 1 b; drop a 1 c",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			tc.f(newEventLog(buf))
+			var got []string
+			dec := json.NewDecoder(buf)
+			for dec.More() {
+				var ev event
+				if err := dec.Decode(&ev); err != nil {
+					t.Fatal(err)
+				}
+				if ev.Time.IsZero() {
+					t.Errorf("%s event has no time", ev.Event)
+				}
+				s := strings.Join([]string{ev.Event, string(ev.Node), fmt.Sprint(ev.Slot), string(ev.To), ev.Phase, ev.Value}, " ")
+				got = append(got, strings.Join(strings.Fields(s), " "))
+			}
+			if s := strings.Join(got, "; "); s != tc.want {
+				t.Errorf("got %s, want %s", s, tc.want)
+			}
+		})
+	}
+
+	// A nil eventLog discards events.
+	var e *eventLog
+	e.sent(nom)
+	e.delivered("b", nom)
+	e.dropped("b", nom)
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bobg/scp"
+)
+
+// Builds a network from a generator spec (with -topology) instead of
+// reading it from a file. The spec is one of:
+//
+//	tiered:4,4,2
+//	  Tiers of the given sizes, named tier1-1, tier1-2, and so on. Each
+//	  node in the top tier trusts two-thirds of that tier; each node in
+//	  a lower tier trusts half of the tier above it.
+//
+//	orgs:5x3@67%
+//	  Five organizations of three nodes each, named org1-1, org1-2, and
+//	  so on. Each node trusts 67% of the organizations (but always at
+//	  least one besides its own), and an organization counts when a
+//	  majority of its nodes agree. The percentage defaults to 67.
+//
+//	random:n=30,k=5,t=3
+//	  N nodes, named n01, n02, and so on, each trusting T of K others
+//	  chosen at random.
+//
+//	smallworld:n=20,k=4,p=0.1,t=3
+//	  N nodes in a ring, each trusting T of its K nearest neighbors,
+//	  except that each neighbor is replaced by a randomly chosen node
+//	  with probability P.
+//
+// For random and smallworld, K defaults to 4 and T to two-thirds of K,
+// and a seed=S parameter (default 1) changes the random choices.
+func generate(spec string) (*config, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("topology %q: want KIND:PARAMS", spec)
+	}
+	var (
+		conf *config
+		err  error
+	)
+	switch parts[0] {
+	case "tiered":
+		conf, err = genTiered(parts[1])
+	case "orgs":
+		conf, err = genOrgs(parts[1])
+	case "random":
+		conf, err = genRandom(parts[1], false)
+	case "smallworld":
+		conf, err = genRandom(parts[1], true)
+	default:
+		return nil, fmt.Errorf("topology %q: unknown kind %q", spec, parts[0])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("topology %q: %s", spec, err)
+	}
+	err = conf.validate()
+	if err != nil {
+		return nil, fmt.Errorf("topology %q: %s", spec, err)
+	}
+	return conf, nil
+}
+
+func newGenConf() *config {
+	return &config{nodes: make(map[string]nodeconf)}
+}
+
+// Produces the QSet requiring t of the given nodes.
+func qsetOf(t int, ids []string) scp.QSet {
+	q := scp.QSet{T: t}
+	for _, id := range ids {
+		nodeID := scp.NodeID(id)
+		q.M = append(q.M, scp.QSetMember{N: &nodeID})
+	}
+	return q
+}
+
+// Returns ids without the given one.
+func without(ids []string, id string) []string {
+	var result []string
+	for _, other := range ids {
+		if other != id {
+			result = append(result, other)
+		}
+	}
+	return result
+}
+
+func ceilDiv(a, b int) int {
+	return (a + b - 1) / b
+}
+
+func genTiered(params string) (*config, error) {
+	var tiers [][]string
+	for i, s := range strings.Split(params, ",") {
+		size, err := strconv.Atoi(s)
+		if err != nil || size < 1 {
+			return nil, fmt.Errorf("bad tier size %q", s)
+		}
+		var tier []string
+		for j := 1; j <= size; j++ {
+			tier = append(tier, fmt.Sprintf("tier%d-%d", i+1, j))
+		}
+		tiers = append(tiers, tier)
+	}
+
+	top := tiers[0]
+	if len(top) < 2 {
+		// A lone top-tier node would be a quorum by itself.
+		return nil, fmt.Errorf("the top tier needs at least two nodes")
+	}
+
+	conf := newGenConf()
+	for _, id := range top {
+		// The node itself counts toward the two-thirds.
+		conf.nodes[id] = nodeconf{Q: qsetOf(ceilDiv(2*len(top), 3)-1, without(top, id))}
+	}
+	for i := 1; i < len(tiers); i++ {
+		above := tiers[i-1]
+		for _, id := range tiers[i] {
+			conf.nodes[id] = nodeconf{Q: qsetOf(ceilDiv(len(above), 2), above)}
+		}
+	}
+	return conf, nil
+}
+
+var orgsRE = regexp.MustCompile(`^(\d+)x(\d+)(?:@(\d+)%)?$`)
+
+func genOrgs(params string) (*config, error) {
+	m := orgsRE.FindStringSubmatch(params)
+	if m == nil {
+		return nil, fmt.Errorf("want ORGSxSIZE or ORGSxSIZE@PCT%%")
+	}
+	numOrgs, _ := strconv.Atoi(m[1])
+	size, _ := strconv.Atoi(m[2])
+	pct := 67
+	if m[3] != "" {
+		pct, _ = strconv.Atoi(m[3])
+	}
+	if numOrgs < 1 || size < 1 {
+		return nil, fmt.Errorf("need at least one organization of at least one node")
+	}
+	if numOrgs*size < 2 {
+		return nil, fmt.Errorf("need at least two nodes")
+	}
+	if pct < 1 || pct > 100 {
+		return nil, fmt.Errorf("percentage %d is not between 1 and 100", pct)
+	}
+
+	orgs := make([][]string, numOrgs)
+	for i := range orgs {
+		for j := 1; j <= size; j++ {
+			orgs[i] = append(orgs[i], fmt.Sprintf("org%d-%d", i+1, j))
+		}
+	}
+	orgT := size/2 + 1
+	t := ceilDiv(pct*numOrgs, 100)
+
+	conf := newGenConf()
+	for i, org := range orgs {
+		for _, id := range org {
+			q := scp.QSet{T: t}
+			for j, other := range orgs {
+				orgQ := qsetOf(orgT, other)
+				if j == i {
+					// The node itself is implicitly one of the votes its
+					// own organization needs.
+					orgQ = qsetOf(orgT-1, without(other, id))
+					if orgQ.T == 0 {
+						// Its own organization is always satisfied.
+						q.T--
+						continue
+					}
+				}
+				q.M = append(q.M, scp.QSetMember{Q: &orgQ})
+			}
+			if q.T < 1 && len(q.M) > 0 {
+				// The node's own organization already makes up the
+				// percentage (e.g. orgs:3x1@30%), but it still needs
+				// one other to avoid being a quorum by itself.
+				q.T = 1
+			}
+			conf.nodes[id] = nodeconf{Q: q}
+		}
+	}
+	return conf, nil
+}
+
+// Parses NAME=VALUE,... parameters, allowing only the given names.
+func genParams(params string, names ...string) (map[string]float64, error) {
+	result := make(map[string]float64)
+	for _, kv := range strings.Split(params, ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("bad parameter %q", kv)
+		}
+		ok := false
+		for _, name := range names {
+			ok = ok || parts[0] == name
+		}
+		if !ok {
+			return nil, fmt.Errorf("unknown parameter %q", parts[0])
+		}
+		v, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %s: %s", parts[0], err)
+		}
+		result[parts[0]] = v
+	}
+	return result, nil
+}
+
+// Generates a random or (if smallWorld is true) small-world network.
+func genRandom(params string, smallWorld bool) (*config, error) {
+	names := []string{"n", "k", "t", "seed"}
+	if smallWorld {
+		names = append(names, "p")
+	}
+	p, err := genParams(params, names...)
+	if err != nil {
+		return nil, err
+	}
+	n, k := int(p["n"]), int(p["k"])
+	if _, ok := p["k"]; !ok {
+		k = 4
+	}
+	if n < 2 {
+		return nil, fmt.Errorf("n must be at least 2")
+	}
+	if k < 1 || k >= n {
+		return nil, fmt.Errorf("k must be between 1 and n-1")
+	}
+	t := ceilDiv(2*k, 3)
+	if v, ok := p["t"]; ok {
+		t = int(v)
+	}
+	if t < 1 || t > k {
+		return nil, fmt.Errorf("t must be between 1 and k")
+	}
+	prob, ok := p["p"]
+	if !ok {
+		prob = 0.1
+	}
+	if prob < 0 || prob > 1 {
+		return nil, fmt.Errorf("p must be between 0 and 1")
+	}
+	seed := int64(1)
+	if v, ok := p["seed"]; ok {
+		seed = int64(v)
+	}
+	rng := rand.New(rand.NewSource(seed))
+
+	width := len(strconv.Itoa(n))
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("n%0*d", width, i+1)
+	}
+
+	conf := newGenConf()
+	for i, id := range ids {
+		var trusted []int
+		if smallWorld {
+			// The nearest neighbors, alternating right and left.
+			chosen := map[int]bool{i: true}
+			for d := 1; len(trusted) < k; d++ {
+				for _, j := range []int{(i + d) % n, (i - d + n) % n} {
+					if len(trusted) < k && !chosen[j] {
+						trusted = append(trusted, j)
+						chosen[j] = true
+					}
+				}
+			}
+			for x, j := range trusted {
+				if rng.Float64() >= prob {
+					continue
+				}
+				var others []int
+				for j2 := 0; j2 < n; j2++ {
+					if !chosen[j2] {
+						others = append(others, j2)
+					}
+				}
+				if len(others) == 0 {
+					break
+				}
+				j2 := others[rng.Intn(len(others))]
+				delete(chosen, j)
+				chosen[j2] = true
+				trusted[x] = j2
+			}
+		} else {
+			for _, j := range rng.Perm(n - 1)[:k] {
+				if j >= i {
+					j++
+				}
+				trusted = append(trusted, j)
+			}
+		}
+		sort.Ints(trusted)
+		var members []string
+		for _, j := range trusted {
+			members = append(members, ids[j])
+		}
+		conf.nodes[id] = nodeconf{Q: qsetOf(t, members)}
+	}
+	return conf, nil
+}
+
+var bareKeyRE = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Writes the nodes of conf and their quorum sets in the config-file
+// format, along with any behavior. Other node settings and tables are
+// not written.
+func writeConf(w io.Writer, conf *config) error {
+	var names []string
+	for name := range conf.nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+	for i, name := range names {
+		if i > 0 {
+			fmt.Fprintln(bw)
+		}
+		key := name
+		if !bareKeyRE.MatchString(name) {
+			key = strconv.Quote(name)
+		}
+		nconf := conf.nodes[name]
+		fmt.Fprintf(bw, "[%s]\nQ = %s\n", key, tomlQSet(nconf.Q))
+		if nconf.Behavior != "" {
+			fmt.Fprintf(bw, "Behavior = %s\n", strconv.Quote(nconf.Behavior))
+		}
+	}
+	return bw.Flush()
+}
+
+func writeConfFile(filename string, conf *config) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	err = writeConf(f, conf)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func tomlQSet(q scp.QSet) string {
+	var members []string
+	for _, m := range q.M {
+		if m.N != nil {
+			members = append(members, fmt.Sprintf("{n = %s}", strconv.Quote(string(*m.N))))
+		} else {
+			members = append(members, fmt.Sprintf("{q = %s}", tomlQSet(*m.Q)))
+		}
+	}
+	return fmt.Sprintf("{t = %d, m = [%s]}", q.T, strings.Join(members, ", "))
+}
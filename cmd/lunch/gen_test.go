@@ -0,0 +1,73 @@
+package main
+
+import "testing"
+
+func TestGenerate(t *testing.T) {
+	cases := []struct {
+		spec  string
+		nodes int
+		node  string // a node whose top-level QSet to check
+		t, m  int    // the threshold and number of members of its QSet
+	}{
+		{spec: "tiered:2", nodes: 2, node: "tier1-1", t: 1, m: 1},
+		{spec: "tiered:4,4,2", nodes: 10, node: "tier1-1", t: 2, m: 3},
+		{spec: "tiered:4,4,2", nodes: 10, node: "tier3-2", t: 2, m: 4},
+		{spec: "tiered:3,1", nodes: 4, node: "tier2-1", t: 2, m: 3},
+		{spec: "orgs:1x3", nodes: 3, node: "org1-2", t: 1, m: 1},
+		{spec: "orgs:3x1", nodes: 3, node: "org1-1", t: 2, m: 2},
+		{spec: "orgs:3x1@30%", nodes: 3, node: "org1-1", t: 1, m: 2},
+		{spec: "orgs:3x1@100%", nodes: 3, node: "org1-1", t: 2, m: 2},
+		{spec: "orgs:5x3", nodes: 15, node: "org2-3", t: 4, m: 5},
+		{spec: "orgs:4x2@50%", nodes: 8, node: "org1-1", t: 2, m: 4},
+		{spec: "random:n=2,k=1", nodes: 2, node: "n1", t: 1, m: 1},
+		{spec: "random:n=30,k=5,t=3", nodes: 30, node: "n01", t: 3, m: 5},
+		{spec: "smallworld:n=5,k=4,p=1", nodes: 5, node: "n1", t: 3, m: 4},
+	}
+	for _, tc := range cases {
+		t.Run(tc.spec, func(t *testing.T) {
+			conf, err := generate(tc.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(conf.nodes) != tc.nodes {
+				t.Errorf("got %d nodes, want %d", len(conf.nodes), tc.nodes)
+			}
+			nconf, ok := conf.nodes[tc.node]
+			if !ok {
+				t.Fatalf("no node %s", tc.node)
+			}
+			if nconf.Q.T != tc.t || len(nconf.Q.M) != tc.m {
+				t.Errorf("node %s has threshold %d of %d members, want %d of %d", tc.node, nconf.Q.T, len(nconf.Q.M), tc.t, tc.m)
+			}
+		})
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	specs := []string{
+		"tiered",
+		"tiered:",
+		"tiered:4,0",
+		"tiered:1",
+		"tiered:1,3",
+		"orgs:0x3",
+		"orgs:3x0",
+		"orgs:1x1",
+		"orgs:3x1@0%",
+		"orgs:3x1@101%",
+		"orgs:3",
+		"random:n=1",
+		"random:n=5,k=5",
+		"random:n=5,k=2,t=3",
+		"random:n=5,t=0",
+		"random:n=5,q=1",
+		"random:n=5,p=0.5",
+		"smallworld:n=5,p=2",
+		"mesh:n=5",
+	}
+	for _, spec := range specs {
+		if _, err := generate(spec); err == nil {
+			t.Errorf("generate(%q) succeeded, want error", spec)
+		}
+	}
+}
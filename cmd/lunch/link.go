@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bobg/scp"
+	"github.com/bobg/scp/fault"
+)
+
+// A linkconf sets the latency and loss of the links between two sets
+// of nodes, read from a [[link]] table in the config file:
+//
+//	[groups]
+//	us = ["alice", "bob"]
+//	eu = ["carol", "dave"]
+//
+//	[[link]]
+//	from = ["us"]
+//	to = ["eu", "erin"]
+//	latency = "normal:80ms,10ms"
+//	loss = 0.02
+//
+// From and To name nodes or groups (from the [groups] table). The
+// link applies in both directions unless OneWay is set. Latency is
+// one of:
+//
+//	fixed:D           always D
+//	uniform:MIN,MAX   uniformly distributed in [MIN,MAX)
+//	exp:MIN,MEAN      MIN plus an exponentially distributed amount with mean MEAN
+//	normal:MEAN,SD    normally distributed
+//
+// and defaults to the -delay setting. Loss is the probability that a
+// message is dropped by the link. It combines with the receiving
+// node's FP/FQ rate: a message is lost if either the link or the node
+// drops it. Where [[link]] tables overlap, later ones take precedence.
+type linkconf struct {
+	From, To []string
+	Latency  string
+	Loss     float64
+	OneWay   bool
+}
+
+// Produces the fault.Link described by lc, starting from the given
+// default.
+func (lc linkconf) link(def fault.Link) (fault.Link, error) {
+	l := def
+	if lc.Loss < 0 || lc.Loss > 1 {
+		return l, fmt.Errorf("loss %v is not a probability", lc.Loss)
+	}
+	l.Drop = lc.Loss
+	if lc.Latency != "" {
+		d, err := parseDist(lc.Latency)
+		if err != nil {
+			return l, err
+		}
+		l.Latency = d
+	}
+	return l, nil
+}
+
+// Parses a latency distribution, as described for linkconf.
+func parseDist(s string) (fault.Dist, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("latency %q: want KIND:PARAMS", s)
+	}
+	var durs []time.Duration
+	for _, p := range strings.Split(parts[1], ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(p))
+		if err != nil {
+			return nil, fmt.Errorf("latency %q: %s", s, err)
+		}
+		durs = append(durs, d)
+	}
+	want := 2
+	if parts[0] == "fixed" {
+		want = 1
+	}
+	if len(durs) != want {
+		return nil, fmt.Errorf("latency %q: want %d durations", s, want)
+	}
+	switch parts[0] {
+	case "fixed":
+		return fault.Fixed(durs[0]), nil
+	case "uniform":
+		return fault.Uniform{Min: durs[0], Max: durs[1]}, nil
+	case "exp":
+		return fault.Exp{Min: durs[0], Mean: durs[1]}, nil
+	case "normal":
+		return fault.Normal{Mean: durs[0], StdDev: durs[1]}, nil
+	}
+	return nil, fmt.Errorf("latency %q: unknown distribution %q", s, parts[0])
+}
+
+// Adds the FP/FQ loss rate of the receiving node to l.
+func (conf *config) withDropRate(l fault.Link, to scp.NodeID) fault.Link {
+	l.Drop = 1 - (1-l.Drop)*(1-conf.dropRate(to))
+	return l
+}
+
+// Expands a list of node and group names to node IDs.
+func (conf *config) expand(names []string) (scp.NodeIDSet, error) {
+	var result scp.NodeIDSet
+	for _, name := range names {
+		if members, ok := conf.groups[name]; ok {
+			for _, m := range members {
+				result = result.Add(scp.NodeID(m))
+			}
+			continue
+		}
+		if _, ok := conf.nodes[name]; ok {
+			result = result.Add(scp.NodeID(name))
+			continue
+		}
+		return nil, fmt.Errorf("unknown node or group %q", name)
+	}
+	return result, nil
+}
+
+// Configures net with the links in conf, on top of the given default.
+func (conf *config) setLinks(net *fault.Injector, def fault.Link) error {
+	for i, lc := range conf.links {
+		l, err := lc.link(def)
+		if err != nil {
+			return fmt.Errorf("link %d: %s", i+1, err)
+		}
+		from, err := conf.expand(lc.From)
+		if err != nil {
+			return fmt.Errorf("link %d: %s", i+1, err)
+		}
+		to, err := conf.expand(lc.To)
+		if err != nil {
+			return fmt.Errorf("link %d: %s", i+1, err)
+		}
+		for _, a := range from {
+			for _, b := range to {
+				if a == b {
+					continue
+				}
+				net.SetLink(a, b, conf.withDropRate(l, b))
+				if !lc.OneWay {
+					net.SetLink(b, a, conf.withDropRate(l, a))
+				}
+			}
+		}
+	}
+	return nil
+}
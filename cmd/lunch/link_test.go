@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bobg/scp"
+	"github.com/bobg/scp/fault"
+)
+
+func TestParseDist(t *testing.T) {
+	cases := []struct {
+		s       string
+		want    fault.Dist
+		wantErr bool
+	}{
+		{s: "fixed:50ms", want: fault.Fixed(50 * time.Millisecond)},
+		{s: "fixed:0s", want: fault.Fixed(0)},
+		{s: "uniform:10ms,1s", want: fault.Uniform{Min: 10 * time.Millisecond, Max: time.Second}},
+		{s: "uniform: 10ms , 20ms", want: fault.Uniform{Min: 10 * time.Millisecond, Max: 20 * time.Millisecond}},
+		{s: "exp:5ms,40ms", want: fault.Exp{Min: 5 * time.Millisecond, Mean: 40 * time.Millisecond}},
+		{s: "normal:80ms,10ms", want: fault.Normal{Mean: 80 * time.Millisecond, StdDev: 10 * time.Millisecond}},
+		{s: "", wantErr: true},
+		{s: "fixed", wantErr: true},
+		{s: "fixed:", wantErr: true},
+		{s: "fixed:50", wantErr: true},
+		{s: "fixed:50ms,60ms", wantErr: true},
+		{s: "uniform:10ms", wantErr: true},
+		{s: "uniform:10ms,20ms,30ms", wantErr: true},
+		{s: "normal:80ms,ten", wantErr: true},
+		{s: "pareto:1ms,2ms", wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.s, func(t *testing.T) {
+			got, err := parseDist(tc.s)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("got %v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %#v, want %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSetupNet(t *testing.T) {
+	conf := testConf(t, "a:1(b) b:1(a) c:1(a)")
+	conf.nodes["b"] = nodeconf{Q: conf.nodes["b"].Q, FP: 1, FQ: 2}
+	conf.links = []linkconf{{From: []string{"a"}, To: []string{"b", "c"}, Loss: 0.5}}
+	net := fault.New(scp.RealClock, 1)
+	if err := conf.setupNet(net, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		from, to scp.NodeID
+		want     float64
+	}{
+		{from: "a", to: "b", want: 0.75}, // link and node together
+		{from: "b", to: "a", want: 0.5},  // link only
+		{from: "a", to: "c", want: 0.5},
+		{from: "c", to: "b", want: 0.5}, // node only
+		{from: "b", to: "c", want: 0},
+	}
+	for _, tc := range cases {
+		t.Run(string(tc.from)+"-"+string(tc.to), func(t *testing.T) {
+			if got := net.Link(tc.from, tc.to).Drop; got != tc.want {
+				t.Errorf("got drop rate %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -1,16 +1,26 @@
 package main
 
 // Usage:
-//   lunch [-seed N] CONFIGFILE
+//   lunch [-seed N] [-delay MS] [-combine S] [-format text|json] [-slots N [-csv FILE] [-stall D]] CONFIGFILE
+//   lunch -sim -slots N [-seed N] [-delay MS] [-combine S] [-csv FILE] [-stall D] CONFIGFILE
+//   lunch -check [-dot FILE [-highlight NODE]] CONFIGFILE
+//   lunch -step [-seed N] [-combine S] CONFIGFILE
+//   lunch -batch M [-parallel P] -slots N [-seed N] [-delay MS] [-combine S] [-stall D] CONFIGFILE
+//
+// CONFIGFILE is TOML, or crawler JSON if its name ends in .json. In
+// place of CONFIGFILE, -topology SPEC generates a network (see
+// generate). Either way, -write FILE saves the nodes and their quorum
+// sets in TOML form.
 
 import (
-	"bytes"
-	"context"
-	"encoding/binary"
 	"flag"
+	"fmt"
 	"io/ioutil"
 	"log"
-	"math/rand"
+	"os"
+	"runtime"
+	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/bobg/scp"
@@ -38,9 +48,7 @@ func (v valType) IsNil() bool {
 }
 
 func (v valType) Bytes() []byte {
-	buf := new(bytes.Buffer)
-	binary.Write(buf, binary.BigEndian, v)
-	return buf.Bytes()
+	return []byte(v)
 }
 
 func (v valType) String() string {
@@ -48,82 +56,254 @@ func (v valType) String() string {
 }
 
 type nodeconf struct {
-	Q  scp.QSet
+	Q scp.QSet
+
+	// FP/FQ is a rational giving the odds that a message to this node
+	// is dropped. FQ==0 is treated as 0/1.
 	FP int
 	FQ int
+
+	// Behavior, if set, makes this node misbehave.
+	// It is one of "silent", "equivocate" (nominate different values to different peers),
+	// "fixed" (vote only for Value), or "inflate" (add Inflate to ballot counters).
+	Behavior string
+	Value    string
+	Inflate  int
+
+	// The node's food preferences, at most one of:
+	//
+	//	favorite = "pizza"                    # always this
+	//	ranked = ["sushi", "indian", "pizza"] # these, in order
+	//	weights = {pizza = 3, soup = 1}       # ranked at random, favoring heavier foods
+	//
+	// A node with none ranks all the foods at random. In each slot the
+	// node nominates a value built from its ranking by the -combine
+	// strategy.
+	Favorite string
+	Ranked   []string
+	Weights  map[string]float64
+}
+
+// A config describes a network: its nodes, keyed by name, the links
+// between them, and any scheduled partitions and crashes.
+type config struct {
+	nodes      map[string]nodeconf
+	groups     map[string][]string
+	links      []linkconf
+	partitions []partitionconf
+	crashes    []crashconf
+}
+
+// Reads the config file: a table of nodeconfs keyed by node name,
+// plus optional [groups], [[link]], [[partition]], and [[crash]]
+// tables. A file whose name ends in .json is instead read as the
+// output of a network crawler (see readCrawlerJSON).
+func readConf(filename string) (*config, error) {
+	if strings.HasSuffix(filename, ".json") {
+		return readCrawlerJSON(filename)
+	}
+	confBits, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]toml.Primitive
+	md, err := toml.Decode(string(confBits), &raw)
+	if err != nil {
+		return nil, err
+	}
+	conf := &config{nodes: make(map[string]nodeconf)}
+	for name, prim := range raw {
+		if name == "partition" {
+			err = md.PrimitiveDecode(prim, &conf.partitions)
+			if err != nil {
+				return nil, fmt.Errorf("partitions: %s", err)
+			}
+			continue
+		}
+		if name == "groups" {
+			err = md.PrimitiveDecode(prim, &conf.groups)
+			if err != nil {
+				return nil, fmt.Errorf("groups: %s", err)
+			}
+			continue
+		}
+		if name == "link" {
+			err = md.PrimitiveDecode(prim, &conf.links)
+			if err != nil {
+				return nil, fmt.Errorf("links: %s", err)
+			}
+			continue
+		}
+		if name == "crash" {
+			err = md.PrimitiveDecode(prim, &conf.crashes)
+			if err != nil {
+				return nil, fmt.Errorf("crashes: %s", err)
+			}
+			continue
+		}
+		var nconf nodeconf
+		err = md.PrimitiveDecode(prim, &nconf)
+		if err != nil {
+			return nil, fmt.Errorf("node %s: %s", name, err)
+		}
+		conf.nodes[name] = nconf
+	}
+	err = conf.validate()
+	if err != nil {
+		return nil, err
+	}
+	for name, members := range conf.groups {
+		if _, ok := conf.nodes[name]; ok {
+			return nil, fmt.Errorf("group %s has the same name as a node", name)
+		}
+		for _, m := range members {
+			if _, ok := conf.nodes[m]; !ok {
+				return nil, fmt.Errorf("group %s: unknown node %q", name, m)
+			}
+		}
+	}
+	for _, cc := range conf.crashes {
+		err = cc.validate(conf)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return conf, nil
 }
 
 func main() {
 	seed := flag.Int64("seed", 1, "RNG seed")
-	delay := flag.Int("delay", 100, "random delay limit in milliseconds")
+	delay := flag.Int("delay", 100, "random delay limit in milliseconds, except as set by [[link]] tables")
+	format := flag.String("format", "text", "output format: text (log messages only) or json (also one event per line on stdout)")
+	numSlots := flag.Int("slots", 0, "stop after this many slots and report statistics (0 means run forever)")
+	csvFile := flag.String("csv", "", "with -slots, also write the statistics to this file in CSV format")
+	stall := flag.Duration("stall", time.Minute, "with -slots, give up if no slot completes for this long")
+	batch := flag.Int("batch", 0, "with -slots, run this many times in simulation with consecutive seeds starting at -seed and report on all runs")
+	simulate := flag.Bool("sim", false, "with -slots, run in deterministic simulated time (as -batch does) rather than in real time")
+	parallel := flag.Int("parallel", runtime.NumCPU(), "with -batch, the number of runs to perform at once")
+	step := flag.Bool("step", false, "interactive mode: deliver, drop, and reorder messages by hand")
+	check := flag.Bool("check", false, "check the topology and exit")
+	topology := flag.String("topology", "", "generate the network from this spec (e.g. tiered:4,4,2) instead of reading CONFFILE")
+	write := flag.String("write", "", "write the network's nodes and quorum sets to this file in TOML form")
+	dot := flag.String("dot", "", "write the trust graph to this file in Graphviz DOT form")
+	highlight := flag.String("highlight", "", "with -dot, highlight a quorum and a blocking set for this node")
+	combine := flag.String("combine", "parity", "how nominated values combine: parity (min or max depending on the slot), majority (plurality of first choices), or ranked (instant runoff)")
 	flag.Parse()
-	rand.Seed(*seed)
 
-	if flag.NArg() < 1 {
-		log.Fatal("usage: lunch [-seed N] CONFFILE")
+	var (
+		conf   *config
+		source string // how to name the network in a command line
+		err    error
+	)
+	if *topology != "" {
+		conf, err = generate(*topology)
+		if err != nil {
+			log.Fatal(err)
+		}
+		source = "-topology " + *topology
+	} else {
+		if flag.NArg() < 1 {
+			log.Fatal("usage: lunch [-seed N] CONFFILE")
+		}
+		conf, err = readConf(flag.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		source = flag.Arg(0)
+	}
+	if *write != "" {
+		err = writeConfFile(*write, conf)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *highlight != "" && *dot == "" {
+		log.Fatal("-highlight requires -dot")
+	}
+	if *dot != "" {
+		err = writeDOTFile(*dot, conf, *highlight)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	warnings := conf.topology().warnings()
+	for _, w := range warnings {
+		log.Printf("WARNING: %s", w)
 	}
-	confFile := flag.Arg(0)
-	confBits, err := ioutil.ReadFile(confFile)
-	if err != nil {
-		log.Fatal(err)
+	if *check {
+		if len(warnings) == 0 {
+			log.Print("no problems found")
+		}
+		return
 	}
-	var conf map[string]nodeconf
-	_, err = toml.Decode(string(confBits), &conf)
+
+	opts := runOpts{
+		seed:  *seed,
+		delay: time.Duration(*delay) * time.Millisecond,
+		slots: *numSlots,
+		stall: *stall,
+	}
+	opts.combine, err = parseStrategy(*combine)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	nodes := make(map[scp.NodeID]*scp.Node)
-	ch := make(chan *scp.Msg)
-	for nodeID, nconf := range conf {
-		node := scp.NewNode(scp.NodeID(nodeID), nconf.Q, ch, nil)
-		node.FP, node.FQ = nconf.FP, nconf.FQ
-		nodes[node.ID] = node
-		go node.Run(context.Background())
+	if *step {
+		err = runStep(conf, opts, os.Stdin, os.Stdout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
-	for slotID := scp.SlotID(1); ; slotID++ {
-		msgs := make(map[scp.NodeID]*scp.Msg) // holds the latest message seen from each node
+	if *batch > 0 {
+		if *numSlots <= 0 {
+			log.Fatal("-batch requires -slots")
+		}
+		runBatch(conf, opts, *batch, *parallel, source)
+		return
+	}
 
-		for _, node := range nodes {
-			msgs[node.ID] = nil
+	switch *format {
+	case "text":
+	case "json":
+		opts.events = newEventLog(os.Stdout)
+	default:
+		log.Fatalf("unknown format %q", *format)
+	}
 
-			// New slot! Nominate something.
-			val := foods[rand.Intn(len(foods))]
-			nomMsg := scp.NewMsg(node.ID, slotID, node.Q, &scp.NomTopic{X: scp.ValueSet{val}})
-			node.Handle(nomMsg)
+	var res *runResult
+	if *simulate {
+		if opts.events != nil {
+			log.Fatal("-sim does not support -format json")
 		}
-
-		for msg := range ch {
-			if msg.I < slotID {
-				// discard messages about old slots
-				continue
-			}
-			msgs[msg.V] = msg
-			allExt := true
-			for _, m := range msgs {
-				if m == nil {
-					allExt = false
-					break
-				}
-				if _, ok := m.T.(*scp.ExtTopic); !ok {
-					allExt = false
-					break
-				}
-			}
-			if allExt {
-				log.Print("all externalized")
-				break
-			}
-			for otherNodeID, otherNode := range nodes {
-				if otherNodeID == msg.V {
-					continue
-				}
-				if *delay > 0 {
-					otherNode.Delay(rand.Intn(*delay))
-				}
-				otherNode.Handle(msg)
-			}
+		if *numSlots <= 0 {
+			log.Fatal("-sim requires -slots")
+		}
+		res, err = runSim(conf, opts)
+	} else {
+		res, err = run(conf, opts)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if res.stalled {
+		log.Printf("stalled after %d slots", len(res.stats.done))
+	}
+	res.stats.logReport()
+	if *csvFile != "" {
+		f, err := os.Create(*csvFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = res.stats.writeCSV(f)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = f.Close()
+		if err != nil {
+			log.Fatal(err)
 		}
 	}
 }
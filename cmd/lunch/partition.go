@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bobg/scp"
+	"github.com/bobg/scp/fault"
+)
+
+// A partitionconf describes a scheduled network split, read from a
+// [[partition]] table in the config file:
+//
+//	[[partition]]
+//	groups = [["alice", "bob"], ["carol", "dave"]]
+//	start = "2s"   # when the split begins (default: immediately)
+//	end = "10s"    # when it heals (default: never)
+//	from = 3       # first slot affected (default: all)
+//	to = 5         # last slot affected (default: all)
+//
+// Nodes in different groups cannot exchange messages while the
+// partition is in effect. Nodes not listed in any group are
+// unaffected. A summary is logged when the partition heals, so a
+// partition with no end is never summarized. Note that a group cut
+// off from its quorums for a slot cannot catch up on that slot until
+// the partition ends, even if other nodes move past it.
+type partitionconf struct {
+	Groups     [][]string
+	Start, End duration
+	From, To   int
+}
+
+type duration struct {
+	time.Duration
+}
+
+func (d *duration) UnmarshalText(text []byte) error {
+	var err error
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
+}
+
+func (pc partitionconf) partition() fault.Partition {
+	p := fault.Partition{
+		Start:    pc.Start.Duration,
+		End:      pc.End.Duration,
+		FromSlot: scp.SlotID(pc.From),
+		ToSlot:   scp.SlotID(pc.To),
+	}
+	for _, g := range pc.Groups {
+		var group []scp.NodeID
+		for _, id := range g {
+			group = append(group, scp.NodeID(id))
+		}
+		p.Groups = append(p.Groups, group)
+	}
+	return p
+}
+
+// A partitionLog follows the nodes' progress during and after a
+// partition, for summarizing once it heals.
+type partitionLog struct {
+	num int
+	p   fault.Partition
+
+	// groupExt[g][slotID] counts the members of group g that
+	// externalized slotID while the partition was in effect.
+	groupExt []map[scp.SlotID]int
+
+	// During is the highest slot externalized by anyone while the
+	// partition was in effect. After healing, agreement on slots
+	// through this one is checked once all nodes have externalized
+	// them.
+	during   scp.SlotID
+	healed   bool
+	reported bool
+}
+
+func newPartitionLog(num int, p fault.Partition) *partitionLog {
+	pl := &partitionLog{num: num, p: p}
+	for range p.Groups {
+		pl.groupExt = append(pl.groupExt, make(map[scp.SlotID]int))
+	}
+	return pl
+}
+
+// Externalized records that a node externalized a slot at the given
+// time.
+func (pl *partitionLog) externalized(nodeID scp.NodeID, slotID scp.SlotID, elapsed time.Duration) {
+	if pl.healed || !pl.p.Active(elapsed, slotID) {
+		return
+	}
+	for g, group := range pl.p.Groups {
+		for _, member := range group {
+			if member == nodeID {
+				pl.groupExt[g][slotID]++
+			}
+		}
+	}
+	if slotID > pl.during {
+		pl.during = slotID
+	}
+}
+
+// Heal reports which groups kept externalizing while the partition
+// was in effect.
+func (pl *partitionLog) heal() {
+	pl.healed = true
+	log.Printf("partition %d healed", pl.num)
+	for g, group := range pl.p.Groups {
+		var first, last scp.SlotID
+		for slotID, count := range pl.groupExt[g] {
+			if count < len(group) {
+				continue
+			}
+			if first == 0 || slotID < first {
+				first = slotID
+			}
+			if slotID > last {
+				last = slotID
+			}
+		}
+		switch {
+		case first == 0:
+			log.Printf("  group %d %v: externalized no slots", g+1, group)
+		case first == last:
+			log.Printf("  group %d %v: externalized slot %d", g+1, group, first)
+		default:
+			log.Printf("  group %d %v: externalized slots %d-%d", g+1, group, first, last)
+		}
+	}
+}
+
+// Check reports, once the partition has healed and all nodes have
+// externalized every slot through allExt, whether they agree on the
+// slots externalized during the partition.
+func (pl *partitionLog) check(allExt scp.SlotID, exts map[scp.NodeID]map[scp.SlotID]scp.Value) {
+	if !pl.healed || pl.reported || pl.during == 0 || allExt < pl.during {
+		return
+	}
+	pl.reported = true
+
+	var disagreements []string
+	for slotID := scp.SlotID(1); slotID <= pl.during; slotID++ {
+		if s := disagreement(slotID, exts); s != "" {
+			disagreements = append(disagreements, s)
+		}
+	}
+	if len(disagreements) == 0 {
+		log.Printf("partition %d: after healing, all nodes agree through slot %d", pl.num, pl.during)
+		return
+	}
+	log.Printf("partition %d: after healing, nodes DISAGREE:", pl.num)
+	for _, s := range disagreements {
+		log.Printf("  %s", s)
+	}
+}
+
+// Describes the values externalized for a slot by different nodes,
+// or returns "" if all nodes externalized the same value.
+func disagreement(slotID scp.SlotID, exts map[scp.NodeID]map[scp.SlotID]scp.Value) string {
+	byVal := make(map[string]scp.NodeIDSet)
+	for nodeID, m := range exts {
+		if v, ok := m[slotID]; ok {
+			s := scp.VString(v)
+			byVal[s] = byVal[s].Add(nodeID)
+		}
+	}
+	if len(byVal) < 2 {
+		return ""
+	}
+	var vals []string
+	for v, nodeIDs := range byVal {
+		vals = append(vals, fmt.Sprintf("%s %v", v, nodeIDs))
+	}
+	sort.Strings(vals)
+	return fmt.Sprintf("slot %d: %s", slotID, strings.Join(vals, ", "))
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bobg/scp"
+	"github.com/bobg/scp/fault"
+	"github.com/bobg/scp/sim"
+)
+
+func TestSchedulePartitions(t *testing.T) {
+	conf := testConf(t, "a:1(b) b:1(a) c:1(a) d:1(a)")
+	conf.partitions = []partitionconf{
+		{
+			Groups: [][]string{{"a"}, {"b", "c"}},
+			Start:  duration{2 * time.Second},
+			End:    duration{5 * time.Second},
+		},
+		{
+			Groups: [][]string{{"a", "b"}, {"d"}},
+			From:   3,
+			To:     4,
+		},
+	}
+	clock := sim.NewClock()
+	net := fault.New(clock, 1)
+	partLogs := conf.schedulePartitions(net)
+	if len(partLogs) != 2 || partLogs[0].num != 1 || partLogs[1].num != 2 {
+		t.Fatalf("got partition logs %+v, want logs numbered 1 and 2", partLogs)
+	}
+
+	// The cases are in time order.
+	cases := []struct {
+		at       time.Duration
+		from, to scp.NodeID
+		slot     scp.SlotID
+		want     bool
+	}{
+		{at: 0, from: "a", to: "b", slot: 1, want: false}, // not started
+		{at: 2 * time.Second, from: "a", to: "b", slot: 1, want: true},
+		{at: 2 * time.Second, from: "c", to: "a", slot: 1, want: true},
+		{at: 2 * time.Second, from: "b", to: "c", slot: 1, want: false}, // same group
+		{at: 2 * time.Second, from: "a", to: "d", slot: 1, want: false}, // before FromSlot
+		{at: 2 * time.Second, from: "a", to: "d", slot: 3, want: true},
+		{at: 2 * time.Second, from: "d", to: "b", slot: 4, want: true},
+		{at: 2 * time.Second, from: "d", to: "b", slot: 5, want: false}, // after ToSlot
+		{at: 2 * time.Second, from: "c", to: "d", slot: 3, want: false}, // c is not in the second partition
+		{at: 5 * time.Second, from: "a", to: "b", slot: 1, want: false}, // healed
+		{at: time.Hour, from: "a", to: "d", slot: 3, want: true},        // never heals
+	}
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("%s/%s-%s/%d", tc.at, tc.from, tc.to, tc.slot), func(t *testing.T) {
+			clock.Advance(tc.at - net.Elapsed())
+			if got := net.Partitioned(tc.from, tc.to, tc.slot); got != tc.want {
+				t.Errorf("got partitioned %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPartitionLog(t *testing.T) {
+	type ext struct {
+		node scp.NodeID
+		slot scp.SlotID
+		at   time.Duration
+	}
+	p := fault.Partition{
+		Groups: [][]scp.NodeID{{"a", "b"}, {"c"}},
+		Start:  time.Second,
+		End:    10 * time.Second,
+	}
+	cases := []struct {
+		name       string
+		exts       []ext
+		wantDuring scp.SlotID
+		wantGroups []map[scp.SlotID]int
+	}{
+		{
+			name:       "nothing",
+			wantGroups: []map[scp.SlotID]int{{}, {}},
+		},
+		{
+			name:       "before start",
+			exts:       []ext{{"a", 1, 0}, {"c", 1, 0}},
+			wantGroups: []map[scp.SlotID]int{{}, {}},
+		},
+		{
+			name:       "during",
+			exts:       []ext{{"a", 1, 2 * time.Second}, {"b", 1, 3 * time.Second}, {"c", 2, 4 * time.Second}},
+			wantDuring: 2,
+			wantGroups: []map[scp.SlotID]int{{1: 2}, {2: 1}},
+		},
+		{
+			name:       "after end",
+			exts:       []ext{{"a", 1, 2 * time.Second}, {"a", 2, 10 * time.Second}},
+			wantDuring: 1,
+			wantGroups: []map[scp.SlotID]int{{1: 1}, {}},
+		},
+		{
+			name:       "unpartitioned node",
+			exts:       []ext{{"d", 3, 2 * time.Second}},
+			wantDuring: 3,
+			wantGroups: []map[scp.SlotID]int{{}, {}},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			pl := newPartitionLog(1, p)
+			for _, e := range tc.exts {
+				pl.externalized(e.node, e.slot, e.at)
+			}
+			if pl.during != tc.wantDuring {
+				t.Errorf("got during %d, want %d", pl.during, tc.wantDuring)
+			}
+			if fmt.Sprint(pl.groupExt) != fmt.Sprint(tc.wantGroups) {
+				t.Errorf("got group counts %v, want %v", pl.groupExt, tc.wantGroups)
+			}
+
+			// Nothing is recorded once the partition heals.
+			pl.heal()
+			pl.externalized("c", 1, 2*time.Second)
+			if fmt.Sprint(pl.groupExt) != fmt.Sprint(tc.wantGroups) {
+				t.Errorf("after healing, got group counts %v, want %v", pl.groupExt, tc.wantGroups)
+			}
+		})
+	}
+}
+
+func TestDisagreement(t *testing.T) {
+	cases := []struct {
+		name string
+		exts map[scp.NodeID]map[scp.SlotID]scp.Value
+		want string
+	}{
+		{name: "none", want: ""},
+		{
+			name: "agree",
+			exts: map[scp.NodeID]map[scp.SlotID]scp.Value{
+				"a": {1: valType("pizza")},
+				"b": {1: valType("pizza")},
+				"c": {2: valType("soup")},
+			},
+			want: "",
+		},
+		{
+			name: "disagree",
+			exts: map[scp.NodeID]map[scp.SlotID]scp.Value{
+				"a": {1: valType("pizza")},
+				"b": {1: valType("soup")},
+				"c": {1: valType("pizza")},
+			},
+			want: "slot 1: pizza [a c], soup [b]",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := disagreement(1, tc.exts); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
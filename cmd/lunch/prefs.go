@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
+
+	"github.com/bobg/scp"
+)
+
+// Checks a node's preferences.
+func (nconf nodeconf) validatePrefs() error {
+	n := 0
+	if nconf.Favorite != "" {
+		n++
+	}
+	if len(nconf.Ranked) > 0 {
+		n++
+	}
+	if len(nconf.Weights) > 0 {
+		n++
+	}
+	if n > 1 {
+		return fmt.Errorf("only one of favorite, ranked, and weights may be given")
+	}
+	seen := make(map[string]bool)
+	for _, food := range nconf.Ranked {
+		if food == "" {
+			return fmt.Errorf("empty food in ranked")
+		}
+		if seen[food] {
+			return fmt.Errorf("%s appears twice in ranked", food)
+		}
+		seen[food] = true
+	}
+	for food, w := range nconf.Weights {
+		if w <= 0 {
+			return fmt.Errorf("weight of %s must be positive", food)
+		}
+	}
+	return nil
+}
+
+// Produces a node's ranking of foods for one slot, according to its
+// preferences.
+func (nconf nodeconf) ranking(rng *rand.Rand) []valType {
+	switch {
+	case nconf.Favorite != "":
+		return []valType{valType(nconf.Favorite)}
+
+	case len(nconf.Ranked) > 0:
+		result := make([]valType, 0, len(nconf.Ranked))
+		for _, food := range nconf.Ranked {
+			result = append(result, valType(food))
+		}
+		return result
+
+	case len(nconf.Weights) > 0:
+		// Draw foods one at a time without replacement, each with
+		// probability proportional to its weight.
+		var (
+			names []string
+			total float64
+		)
+		for food, w := range nconf.Weights {
+			names = append(names, food)
+			total += w
+		}
+		sort.Strings(names)
+		var result []valType
+		for len(names) > 0 {
+			r := rng.Float64() * total
+			i := 0
+			for ; i < len(names)-1; i++ {
+				r -= nconf.Weights[names[i]]
+				if r < 0 {
+					break
+				}
+			}
+			result = append(result, valType(names[i]))
+			total -= nconf.Weights[names[i]]
+			names = append(names[:i], names[i+1:]...)
+		}
+		return result
+	}
+
+	result := make([]valType, 0, len(foods))
+	for _, i := range rng.Perm(len(foods)) {
+		result = append(result, foods[i])
+	}
+	return result
+}
+
+// A strategy determines what the nodes vote on, and so how
+// Value.Combine reduces the nominated candidates to one.
+type strategy string
+
+const (
+	// Nodes vote on single foods; the combination of two is the lesser
+	// in odd-numbered slots and the greater in even-numbered ones.
+	combineParity strategy = "parity"
+
+	// Nodes vote on ballot boxes, each initially holding the node's own
+	// ranking; the combination of two is the union of their votes. The
+	// box's winner is the most common first choice.
+	combineMajority strategy = "majority"
+
+	// As with combineMajority, but the winner is chosen by instant
+	// runoff over the full rankings.
+	combineRanked strategy = "ranked"
+)
+
+func parseStrategy(s string) (strategy, error) {
+	switch strategy(s) {
+	case combineParity, combineMajority, combineRanked:
+		return strategy(s), nil
+	}
+	return "", fmt.Errorf("unknown combine strategy %q", s)
+}
+
+// Produces the value a voter nominates, given its ranking of foods.
+func (s strategy) value(voter scp.NodeID, ranking []valType) scp.Value {
+	if s == combineMajority || s == combineRanked {
+		return ballotBox{method: s, votes: []vote{{voter: voter, ranking: ranking}}}
+	}
+	return ranking[0]
+}
+
+type vote struct {
+	voter   scp.NodeID
+	ranking []valType
+}
+
+func (v vote) bytes() []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteString(string(v.voter))
+	for _, food := range v.ranking {
+		buf.WriteByte(0)
+		buf.WriteString(string(food))
+	}
+	buf.WriteByte(1)
+	return buf.Bytes()
+}
+
+// A ballotBox is a value holding at most one vote from each node,
+// sorted by voter.
+type ballotBox struct {
+	method strategy
+	votes  []vote
+}
+
+func (b ballotBox) Less(other scp.Value) bool {
+	return bytes.Compare(b.Bytes(), other.Bytes()) < 0
+}
+
+func (b ballotBox) Combine(other scp.Value, _ scp.SlotID) scp.Value {
+	o := other.(ballotBox)
+	result := ballotBox{method: b.method}
+	i, j := 0, 0
+	for i < len(b.votes) && j < len(o.votes) {
+		switch {
+		case b.votes[i].voter < o.votes[j].voter:
+			result.votes = append(result.votes, b.votes[i])
+			i++
+		case b.votes[i].voter > o.votes[j].voter:
+			result.votes = append(result.votes, o.votes[j])
+			j++
+		default:
+			// The same voter in both, possibly with different rankings
+			// if it equivocated. Keep the lesser one.
+			v := b.votes[i]
+			if bytes.Compare(o.votes[j].bytes(), v.bytes()) < 0 {
+				v = o.votes[j]
+			}
+			result.votes = append(result.votes, v)
+			i++
+			j++
+		}
+	}
+	result.votes = append(result.votes, b.votes[i:]...)
+	result.votes = append(result.votes, o.votes[j:]...)
+	return result
+}
+
+func (b ballotBox) IsNil() bool {
+	return len(b.votes) == 0
+}
+
+func (b ballotBox) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	for _, v := range b.votes {
+		buf.Write(v.bytes())
+	}
+	return buf.Bytes()
+}
+
+func (b ballotBox) String() string {
+	if len(b.votes) == 1 {
+		return fmt.Sprintf("%s (%s, 1 vote)", b.winner(), b.method)
+	}
+	return fmt.Sprintf("%s (%s, %d votes)", b.winner(), b.method, len(b.votes))
+}
+
+// Tallies the votes in the box. Ties go to the alphabetically first
+// food.
+func (b ballotBox) winner() valType {
+	eliminated := make(map[valType]bool)
+	for {
+		// Count each vote for its highest-ranked food still in the
+		// running.
+		counts := make(map[valType]int)
+		total := 0
+		for _, v := range b.votes {
+			for _, food := range v.ranking {
+				if !eliminated[food] {
+					counts[food]++
+					total++
+					break
+				}
+			}
+		}
+		if len(counts) == 0 {
+			return ""
+		}
+		var candidates []valType
+		for food := range counts {
+			candidates = append(candidates, food)
+		}
+		sort.Slice(candidates, func(i, j int) bool { return candidates[i] < candidates[j] })
+		leader, loser := candidates[0], candidates[len(candidates)-1]
+		for _, food := range candidates {
+			if counts[food] > counts[leader] {
+				leader = food
+			}
+		}
+		for i := len(candidates) - 1; i >= 0; i-- {
+			if food := candidates[i]; counts[food] < counts[loser] {
+				loser = food
+			}
+		}
+		if b.method != combineRanked || len(candidates) == 1 || 2*counts[leader] > total {
+			return leader
+		}
+		eliminated[loser] = true
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bobg/scp"
+)
+
+func TestWinner(t *testing.T) {
+	cases := []struct {
+		name   string
+		method strategy
+		votes  []string // each a space-separated ranking
+		want   valType
+	}{
+		{name: "no votes", method: combineMajority, want: ""},
+		{name: "empty rankings", method: combineRanked, votes: []string{"", ""}, want: ""},
+		{name: "one vote", method: combineMajority, votes: []string{"sushi pizza"}, want: "sushi"},
+		{name: "plurality", method: combineMajority, votes: []string{"pizza sushi", "sushi pizza", "sushi"}, want: "sushi"},
+		{name: "tie", method: combineMajority, votes: []string{"sushi", "pizza"}, want: "pizza"},
+		{name: "ranked tie", method: combineRanked, votes: []string{"sushi", "pizza"}, want: "pizza"},
+		{
+			name:   "plurality without majority",
+			method: combineMajority,
+			votes:  []string{"pizza", "pizza", "sushi soup", "soup sushi", "soup sushi"},
+			want:   "pizza",
+		},
+		{
+			// Sushi is eliminated first, and its vote goes to soup.
+			name:   "runoff",
+			method: combineRanked,
+			votes:  []string{"pizza", "pizza", "sushi soup", "soup sushi", "soup sushi"},
+			want:   "soup",
+		},
+		{
+			// Of the foods tied for last, the alphabetically last is
+			// eliminated first, so burgers survives the first round.
+			name:   "runoff elimination order",
+			method: combineRanked,
+			votes:  []string{"pizza", "pizza", "burgers soup", "soup burgers", "sushi burgers"},
+			want:   "burgers",
+		},
+		{
+			// A vote whose foods are all eliminated no longer counts
+			// toward the majority.
+			name:   "exhausted votes",
+			method: combineRanked,
+			votes:  []string{"pizza", "pizza", "sushi", "soup", "burgers"},
+			want:   "pizza",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			box := ballotBox{method: tc.method}
+			for i, r := range tc.votes {
+				v := vote{voter: scp.NodeID(string(rune('a' + i)))}
+				for _, food := range strings.Fields(r) {
+					v.ranking = append(v.ranking, valType(food))
+				}
+				box.votes = append(box.votes, v)
+			}
+			if got := box.winner(); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
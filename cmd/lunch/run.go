@@ -0,0 +1,431 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/bobg/scp"
+	"github.com/bobg/scp/fault"
+)
+
+type runOpts struct {
+	seed  int64
+	delay time.Duration // upper limit of the random delay on each message
+
+	// Slots is the number of slots after which to stop. Zero means run
+	// forever.
+	slots int
+
+	// Stall is how long to wait, when slots > 0, for the next slot to
+	// complete before giving up.
+	stall time.Duration
+
+	combine strategy
+
+	events *eventLog // nil unless -format json
+}
+
+type runResult struct {
+	stats      *statsCollector
+	stalled    bool
+	violations []*scp.Violation
+}
+
+// Runs the network described by conf until opts.slots slots have been
+// externalized by all nodes (not counting any that are down or
+// silent), or until it stalls.
+func run(conf *config, opts runOpts) (*runResult, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rng := rand.New(rand.NewSource(opts.seed))
+	events := opts.events
+
+	var (
+		ch      = make(chan *scp.Msg)
+		net     = fault.New(nil, opts.seed)
+		nodes   = make(map[scp.NodeID]*scp.Node)
+		nodeIDs scp.NodeIDSet
+
+		// Each node's record of externalized values, which survives a
+		// crash.
+		extMaps = make(map[scp.NodeID]map[scp.SlotID]*scp.ExtTopic)
+
+		stop    = make(map[scp.NodeID]context.CancelFunc) // stops a node's Run
+		stopped = make(map[scp.NodeID]chan struct{})      // closed when a node's Run returns
+		crashed = make(map[scp.NodeID]bool)
+	)
+	newNode := func(id scp.NodeID, q scp.QSet) *scp.Node {
+		node := scp.NewNode(id, q, ch, extMaps[id])
+		// A Byzantine peer may send a conflicting EXTERNALIZE. Report it
+		// rather than crash; the Monitor reports any disagreement among
+		// honest nodes.
+		node.OnDisagreement = func(d *scp.Disagreement) {
+			log.Printf("node %s: %s", id, d)
+		}
+		return node
+	}
+	startNode := func(node *scp.Node) {
+		nctx, ncancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		stop[node.ID] = ncancel
+		stopped[node.ID] = done
+		net.Add(node.ID, deliverer{node: node, events: events})
+		go func() {
+			node.Run(nctx)
+			close(done)
+		}()
+	}
+	for nodeID, nconf := range conf.nodes {
+		id := scp.NodeID(nodeID)
+		extMaps[id] = make(map[scp.SlotID]*scp.ExtTopic)
+		node := newNode(id, nconf.Q)
+		nodes[id] = node
+		nodeIDs = nodeIDs.Add(id)
+		startNode(node)
+	}
+	defer func() {
+		// Unblock any node still trying to send when the run ends.
+		go func() {
+			for range ch {
+			}
+		}()
+	}()
+
+	behaviors, faulty, err := conf.behaviors(nodeIDs, opts.combine)
+	if err != nil {
+		return nil, err
+	}
+	err = conf.setupNet(net, opts.delay)
+	if err != nil {
+		return nil, err
+	}
+
+	healed := make(chan int)
+	partLogs := conf.schedulePartitions(net)
+	for i, pl := range partLogs {
+		if p := pl.p; p.End > 0 {
+			i := i
+			time.AfterFunc(p.End, func() {
+				select {
+				case healed <- i:
+				case <-ctx.Done():
+				}
+			})
+		}
+	}
+
+	mon := newMonitor(faulty, opts, nil)
+
+	// Sends msg to the given node,
+	// or sends its altered form if the sender misbehaves.
+	sendTo := func(msg *scp.Msg, to scp.NodeID) {
+		if b := behaviors[msg.V]; b != nil {
+			if msg = b.alter(msg, to); msg == nil {
+				return
+			}
+		}
+		if !net.Send(to, msg) {
+			events.dropped(to, msg)
+		}
+	}
+
+	// Sends msg to the other nodes.
+	send := func(msg *scp.Msg) {
+		for _, to := range nodeIDs {
+			if to != msg.V {
+				sendTo(msg, to)
+			}
+		}
+	}
+
+	crashCh := make(chan int)
+	restartCh := make(chan int)
+	for i, cc := range conf.crashes {
+		i := i
+		time.AfterFunc(cc.At.Duration, func() {
+			select {
+			case crashCh <- i:
+			case <-ctx.Done():
+			}
+		})
+	}
+
+	tr := newTracker(conf, nodeIDs, mon, partLogs, time.Now, net.Elapsed)
+	tr.down = func(nodeID scp.NodeID) bool { return crashed[nodeID] }
+	nominate := func(node *scp.Node, slotID scp.SlotID) {
+		val := tr.nominated(node.ID, slotID, opts.combine, rng)
+		node.Handle(scp.NewMsg(node.ID, slotID, node.QSetFor(slotID), &scp.NomTopic{X: scp.ValueSet{val}}))
+	}
+	latest := make(map[scp.NodeID]*scp.Msg) // the latest message from each node
+	for _, nodeID := range nodeIDs {
+		nominate(nodes[nodeID], 1)
+	}
+
+	result := &runResult{stats: tr.stats}
+	defer func() {
+		result.violations = mon.Violations()
+	}()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			mon.CheckLiveness()
+			if opts.slots > 0 && opts.stall > 0 && time.Since(tr.lastExt) > opts.stall {
+				result.stalled = true
+				return result, nil
+			}
+
+		case i := <-healed:
+			partLogs[i].heal()
+
+			// Nodes may have gone quiet while waiting to hear from the
+			// other side. Repeat everyone's latest message.
+			for _, nodeID := range nodeIDs {
+				if msg := latest[nodeID]; msg != nil && !crashed[nodeID] {
+					send(msg)
+				}
+			}
+
+		case i := <-crashCh:
+			cc := conf.crashes[i]
+			id := scp.NodeID(cc.Node)
+			if crashed[id] {
+				break
+			}
+			log.Printf("node %s crashed", id)
+			crashed[id] = true
+			stop[id]()
+			net.Add(id, discard{})
+			if cc.Restart.Duration > 0 {
+				done := stopped[id]
+				time.AfterFunc(cc.Restart.Duration-net.Elapsed(), func() {
+					<-done
+					select {
+					case restartCh <- i:
+					case <-ctx.Done():
+					}
+				})
+			}
+
+		case i := <-restartCh:
+			cc := conf.crashes[i]
+			id := scp.NodeID(cc.Node)
+			node := nodes[id]
+			if cc.Keep != keepState {
+				node = newNode(id, node.Q)
+				nodes[id] = node
+			}
+			delete(crashed, id)
+			startNode(node)
+			if cc.Keep == keepState {
+				log.Printf("node %s restarted with its slot state", id)
+			} else {
+				log.Printf("node %s restarted with its externalized values through slot %d", id, tr.next[id]-1)
+				nominate(node, tr.next[id])
+			}
+
+			// Catch up on what the others have been saying.
+			for _, other := range nodeIDs {
+				if msg := latest[other]; msg != nil && other != id {
+					sendTo(msg, id)
+				}
+			}
+
+		case msg := <-ch:
+			if crashed[msg.V] {
+				// Sent just before crashing.
+				break
+			}
+			events.sent(msg)
+			latest[msg.V] = msg
+			if tr.sent(msg) {
+				if opts.slots > 0 && tr.allExt >= scp.SlotID(opts.slots) {
+					return result, nil
+				}
+				nominate(nodes[msg.V], tr.next[msg.V])
+			}
+
+			send(msg)
+		}
+	}
+}
+
+// Builds the behaviors of the misbehaving nodes among nodeIDs, and
+// tells which nodes those are.
+func (conf *config) behaviors(nodeIDs scp.NodeIDSet, combine strategy) (map[scp.NodeID]behavior, scp.NodeIDSet, error) {
+	behaviors := make(map[scp.NodeID]behavior)
+	var faulty scp.NodeIDSet
+	for _, nodeID := range nodeIDs {
+		nconf := conf.nodes[string(nodeID)]
+		b, err := newBehavior(nconf, nodeIDs, combine)
+		if err != nil {
+			return nil, nil, fmt.Errorf("node %s: %s", nodeID, err)
+		}
+		if b != nil {
+			log.Printf("node %s is %s", nodeID, nconf.Behavior)
+			behaviors[nodeID] = b
+			faulty = faulty.Add(nodeID)
+		}
+	}
+	return behaviors, faulty, nil
+}
+
+// Configures the links in net: a random delay of up to delay by
+// default, plus each node's FP/FQ loss rate and any [[link]] tables.
+func (conf *config) setupNet(net *fault.Injector, delay time.Duration) error {
+	var link fault.Link
+	if delay > 0 {
+		link.Latency = fault.Uniform{Max: delay}
+	}
+	net.SetDefault(link)
+	for nodeID := range conf.nodes {
+		drop := conf.dropRate(scp.NodeID(nodeID))
+		if drop == 0 {
+			continue
+		}
+		l := link
+		l.Drop = drop
+		for otherID := range conf.nodes {
+			if otherID != nodeID {
+				net.SetLink(scp.NodeID(otherID), scp.NodeID(nodeID), l)
+			}
+		}
+	}
+	return conf.setLinks(net, link)
+}
+
+// Tells the probability, from its FP/FQ setting, that a message to
+// the given node is dropped.
+func (conf *config) dropRate(nodeID scp.NodeID) float64 {
+	nconf := conf.nodes[string(nodeID)]
+	if nconf.FQ <= 0 || nconf.FP <= 0 {
+		return 0
+	}
+	return float64(nconf.FP) / float64(nconf.FQ)
+}
+
+// Schedules the configured partitions in net, returning a log for
+// each.
+func (conf *config) schedulePartitions(net *fault.Injector) []*partitionLog {
+	var partLogs []*partitionLog
+	for i, pc := range conf.partitions {
+		p := pc.partition()
+		net.Schedule(p)
+		partLogs = append(partLogs, newPartitionLog(i+1, p))
+	}
+	return partLogs
+}
+
+// Produces a Monitor for a run, reporting violations to the log. A
+// nil clock means real time.
+func newMonitor(faulty scp.NodeIDSet, opts runOpts, clock scp.Clock) *scp.Monitor {
+	mon := &scp.Monitor{
+		Faulty:     faulty,
+		StallAfter: time.Minute,
+		Clock:      clock,
+		OnViolation: func(v *scp.Violation) {
+			log.Printf("INVARIANT VIOLATION: %s", v)
+			for _, msg := range v.History {
+				log.Printf("  %s", msg)
+			}
+		},
+	}
+	if opts.stall > 0 {
+		mon.StallAfter = opts.stall
+	}
+	return mon
+}
+
+// A tracker follows the nodes' progress through the slots from the
+// messages they send. Each node proceeds to the next slot as soon as
+// it externalizes the current one, so that (e.g.) one side of a
+// partition can make progress while the other stalls.
+type tracker struct {
+	conf     *config
+	nodeIDs  scp.NodeIDSet
+	stats    *statsCollector
+	mon      *scp.Monitor
+	partLogs []*partitionLog
+	now      func() time.Time
+	elapsed  func() time.Duration  // time since the network started
+	down     func(scp.NodeID) bool // if non-nil, tells whether a node is down
+
+	next    map[scp.NodeID]scp.SlotID               // the slot each node is working on
+	exts    map[scp.NodeID]map[scp.SlotID]scp.Value // values each node has externalized
+	allExt  scp.SlotID                              // the highest slot externalized by all nodes
+	lastExt time.Time                               // when allExt last increased
+}
+
+func newTracker(conf *config, nodeIDs scp.NodeIDSet, mon *scp.Monitor, partLogs []*partitionLog, now func() time.Time, elapsed func() time.Duration) *tracker {
+	tr := &tracker{
+		conf:     conf,
+		nodeIDs:  nodeIDs,
+		stats:    newStatsCollector(now),
+		mon:      mon,
+		partLogs: partLogs,
+		now:      now,
+		elapsed:  elapsed,
+		next:     make(map[scp.NodeID]scp.SlotID),
+		exts:     make(map[scp.NodeID]map[scp.SlotID]scp.Value),
+		lastExt:  now(),
+	}
+	for _, nodeID := range nodeIDs {
+		tr.next[nodeID] = 1
+		tr.exts[nodeID] = make(map[scp.SlotID]scp.Value)
+	}
+	return tr
+}
+
+// Records that a node is nominating for a slot, and chooses the value
+// it nominates.
+func (tr *tracker) nominated(nodeID scp.NodeID, slotID scp.SlotID, combine strategy, rng *rand.Rand) scp.Value {
+	tr.stats.nominated(nodeID, slotID)
+	return combine.value(nodeID, tr.conf.nodes[string(nodeID)].ranking(rng))
+}
+
+// Records a message sent by a node. It tells whether the message
+// externalizes the slot the sender was working on, in which case the
+// sender should go on to nominate for the next one.
+func (tr *tracker) sent(msg *scp.Msg) bool {
+	tr.stats.sent(msg)
+	tr.mon.Observe(msg)
+
+	topic, ok := msg.T.(*scp.ExtTopic)
+	if !ok || msg.I != tr.next[msg.V] {
+		return false
+	}
+	tr.exts[msg.V][msg.I] = topic.C.X
+	elapsed := tr.elapsed()
+	for _, pl := range tr.partLogs {
+		pl.externalized(msg.V, msg.I, elapsed)
+	}
+	tr.next[msg.V]++
+	for tr.allExt < msg.I {
+		done := true
+		for _, nodeID := range tr.nodeIDs {
+			if (tr.down == nil || !tr.down(nodeID)) && !tr.conf.silent(nodeID) && tr.next[nodeID] <= tr.allExt+1 {
+				done = false
+				break
+			}
+		}
+		if !done {
+			break
+		}
+		tr.allExt++
+		tr.lastExt = tr.now()
+		log.Printf("all externalized slot %d", tr.allExt)
+		tr.stats.externalized(tr.allExt, tr.exts[msg.V][tr.allExt])
+		tr.mon.Forget(tr.allExt)
+	}
+	for _, pl := range tr.partLogs {
+		pl.check(tr.allExt, tr.exts)
+	}
+	return true
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/bobg/scp"
+	"github.com/bobg/scp/sim"
+)
+
+// Like run, but in the deterministic simulation of package sim rather
+// than in real time. Nothing sleeps, and a given seed always produces
+// the same run, so any run can be replayed exactly. Times (slot
+// latencies, opts.stall) are in virtual time. It requires opts.slots >
+// 0 and does not support crashes.
+func runSim(conf *config, opts runOpts) (*runResult, error) {
+	if opts.slots <= 0 {
+		return nil, errors.New("simulation requires -slots")
+	}
+	if len(conf.crashes) > 0 {
+		return nil, errors.New("simulation does not support [[crash]] tables")
+	}
+
+	rng := rand.New(rand.NewSource(opts.seed))
+	s := sim.New(opts.seed)
+
+	var nodeIDs scp.NodeIDSet
+	for nodeID := range conf.nodes {
+		nodeIDs = nodeIDs.Add(scp.NodeID(nodeID))
+	}
+	for _, id := range nodeIDs {
+		id := id
+		node := s.AddNode(id, conf.nodes[string(id)].Q)
+		node.OnDisagreement = func(d *scp.Disagreement) {
+			log.Printf("node %s: %s", id, d)
+		}
+	}
+
+	behaviors, faulty, err := conf.behaviors(nodeIDs, opts.combine)
+	if err != nil {
+		return nil, err
+	}
+	alter := func(msg *scp.Msg, to scp.NodeID) *scp.Msg {
+		if b := behaviors[msg.V]; b != nil {
+			return b.alter(msg, to)
+		}
+		return msg
+	}
+	s.Alter = alter
+	err = conf.setupNet(s.Net, opts.delay)
+	if err != nil {
+		return nil, err
+	}
+
+	latest := make(map[scp.NodeID]*scp.Msg) // the latest message from each node
+	partLogs := conf.schedulePartitions(s.Net)
+	for _, pl := range partLogs {
+		pl := pl
+		if p := pl.p; p.End > 0 {
+			s.Clock.AfterFunc(p.End, func() {
+				pl.heal()
+
+				// Nodes may have gone quiet while waiting to hear from the
+				// other side. Repeat everyone's latest message.
+				for _, nodeID := range nodeIDs {
+					msg := latest[nodeID]
+					if msg == nil {
+						continue
+					}
+					for _, to := range nodeIDs {
+						if to == nodeID {
+							continue
+						}
+						if m := alter(msg, to); m != nil {
+							s.Net.Send(to, m)
+						}
+					}
+				}
+			})
+		}
+	}
+
+	mon := newMonitor(faulty, opts, s.Clock)
+	tr := newTracker(conf, nodeIDs, mon, partLogs, s.Clock.Now, s.Net.Elapsed)
+	nominate := func(nodeID scp.NodeID, slotID scp.SlotID) {
+		s.Nominate(nodeID, slotID, tr.nominated(nodeID, slotID, opts.combine, rng))
+	}
+	s.OnSend = func(msg *scp.Msg) {
+		latest[msg.V] = msg
+		if tr.sent(msg) && tr.allExt < scp.SlotID(opts.slots) {
+			nominate(msg.V, tr.next[msg.V])
+		}
+	}
+	for _, nodeID := range nodeIDs {
+		nominate(nodeID, 1)
+	}
+
+	result := &runResult{stats: tr.stats}
+	defer func() {
+		result.violations = mon.Violations()
+	}()
+
+	nextCheck := s.Clock.Now().Add(time.Second)
+	for tr.allExt < scp.SlotID(opts.slots) {
+		if !s.Step() {
+			// Nothing left to happen.
+			result.stalled = true
+			break
+		}
+		now := s.Clock.Now()
+		if now.Before(nextCheck) {
+			continue
+		}
+		nextCheck = now.Add(time.Second)
+		mon.CheckLiveness()
+		if opts.stall > 0 && now.Sub(tr.lastExt) > opts.stall {
+			result.stalled = true
+			break
+		}
+	}
+	return result, nil
+}
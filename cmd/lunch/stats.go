@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/bobg/scp"
+)
+
+// A slotStats summarizes the run of one slot.
+type slotStats struct {
+	Slot scp.SlotID
+
+	// Duration is the time from the first nomination for the slot
+	// until all nodes externalized it.
+	Duration time.Duration
+
+	// Msgs is the number of messages sent about the slot (before any
+	// fan-out to recipients) by the time all nodes externalized it.
+	Msgs int
+
+	// MaxBN is the highest ballot counter any node reached.
+	MaxBN int
+
+	// Rounds is the highest nomination round any node reached before
+	// it began balloting.
+	Rounds int
+
+	Value string
+
+	start   time.Time
+	nomTime map[scp.NodeID]time.Time // when each node began nominating
+	balTime map[scp.NodeID]time.Time // when each node began balloting
+}
+
+// A statsCollector accumulates slotStats from the messages in a run.
+type statsCollector struct {
+	slots map[scp.SlotID]*slotStats
+	done  []*slotStats
+	now   func() time.Time
+}
+
+// Produces a statsCollector that tells the time with now (e.g.
+// time.Now, or a simulation's virtual clock).
+func newStatsCollector(now func() time.Time) *statsCollector {
+	return &statsCollector{slots: make(map[scp.SlotID]*slotStats), now: now}
+}
+
+func (sc *statsCollector) get(slotID scp.SlotID) *slotStats {
+	ss, ok := sc.slots[slotID]
+	if !ok {
+		ss = &slotStats{
+			Slot:    slotID,
+			nomTime: make(map[scp.NodeID]time.Time),
+			balTime: make(map[scp.NodeID]time.Time),
+		}
+		sc.slots[slotID] = ss
+	}
+	return ss
+}
+
+// Nominated records that a node began nominating for a slot.
+func (sc *statsCollector) nominated(nodeID scp.NodeID, slotID scp.SlotID) {
+	ss := sc.get(slotID)
+	now := sc.now()
+	if ss.start.IsZero() {
+		ss.start = now
+	}
+	ss.nomTime[nodeID] = now
+}
+
+// Sent records a message sent by a node.
+func (sc *statsCollector) sent(msg *scp.Msg) {
+	ss, ok := sc.slots[msg.I]
+	if !ok {
+		// Messages about a slot that is already done, or that this
+		// node has not nominated for.
+		return
+	}
+	ss.Msgs++
+
+	bn := ballotN(msg)
+	if bn > ss.MaxBN {
+		ss.MaxBN = bn
+	}
+	if _, ok := msg.T.(*scp.NomTopic); ok {
+		return
+	}
+	if _, ok := ss.balTime[msg.V]; ok {
+		return
+	}
+	now := sc.now()
+	ss.balTime[msg.V] = now
+	if nomTime, ok := ss.nomTime[msg.V]; ok {
+		if r := scp.NomRound(now.Sub(nomTime)); r > ss.Rounds {
+			ss.Rounds = r
+		}
+	}
+}
+
+// Externalized records that all nodes have externalized the given
+// value for a slot.
+func (sc *statsCollector) externalized(slotID scp.SlotID, v scp.Value) {
+	ss := sc.get(slotID)
+	ss.Duration = sc.now().Sub(ss.start)
+	ss.Value = scp.VString(v)
+	sc.done = append(sc.done, ss)
+	delete(sc.slots, slotID)
+}
+
+// Tells the sender's ballot counter in a message. (EXTERNALIZE
+// messages have none; their HN may stand for infinity.)
+func ballotN(msg *scp.Msg) int {
+	switch topic := msg.T.(type) {
+	case *scp.NomPrepTopic:
+		return topic.B.N
+	case *scp.PrepTopic:
+		return topic.B.N
+	case *scp.CommitTopic:
+		return topic.B.N
+	}
+	return 0
+}
+
+// Logs a report on the completed slots.
+func (sc *statsCollector) logReport() {
+	log.Print("slot  duration   msgs  max B.N  rounds  value")
+	var total time.Duration
+	for _, ss := range sc.done {
+		log.Printf("%4d  %8s  %5d  %7d  %6d  %s", ss.Slot, ss.Duration.Round(time.Millisecond), ss.Msgs, ss.MaxBN, ss.Rounds, ss.Value)
+		total += ss.Duration
+	}
+	if len(sc.done) > 0 {
+		log.Printf("%d slots in %s, mean %s per slot", len(sc.done), total.Round(time.Millisecond), (total / time.Duration(len(sc.done))).Round(time.Millisecond))
+	}
+}
+
+// Writes a report on the completed slots in CSV format.
+func (sc *statsCollector) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"slot", "duration_ms", "msgs", "max_bn", "rounds", "value"})
+	for _, ss := range sc.done {
+		cw.Write([]string{
+			strconv.Itoa(int(ss.Slot)),
+			fmt.Sprintf("%.3f", float64(ss.Duration)/float64(time.Millisecond)),
+			strconv.Itoa(ss.Msgs),
+			strconv.Itoa(ss.MaxBN),
+			strconv.Itoa(ss.Rounds),
+			ss.Value,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
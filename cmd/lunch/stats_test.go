@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bobg/scp"
+)
+
+func TestStatsCollector(t *testing.T) {
+	var q scp.QSet
+	nom := func(v scp.NodeID, i scp.SlotID) *scp.Msg {
+		return scp.NewMsg(v, i, q, &scp.NomTopic{X: scp.ValueSet{valType("pizza")}})
+	}
+	prep := func(v scp.NodeID, i scp.SlotID, n int) *scp.Msg {
+		return scp.NewMsg(v, i, q, &scp.PrepTopic{B: scp.Ballot{N: n, X: valType("pizza")}})
+	}
+	commit := func(v scp.NodeID, i scp.SlotID, n int) *scp.Msg {
+		return scp.NewMsg(v, i, q, &scp.CommitTopic{B: scp.Ballot{N: n, X: valType("pizza")}, CN: n, HN: n})
+	}
+	ext := func(v scp.NodeID, i scp.SlotID) *scp.Msg {
+		return scp.NewMsg(v, i, q, &scp.ExtTopic{C: scp.Ballot{N: 1, X: valType("pizza")}, HN: 1})
+	}
+
+	// A step advances the clock by the given amount, then does one of
+	// the following: begins nominating (nominate), sends a message
+	// (msg), or externalizes the slot (ext).
+	type step struct {
+		after    time.Duration
+		nominate scp.NodeID
+		msg      *scp.Msg
+		ext      bool
+	}
+	cases := []struct {
+		name    string
+		steps   []step
+		want    slotStats // slot 1
+		wantCSV string
+	}{
+		{
+			name: "one round",
+			steps: []step{
+				{nominate: "a"},
+				{msg: nom("a", 1)},
+				{after: time.Millisecond, nominate: "b"},
+				{msg: prep("a", 1, 1)},
+				{msg: commit("b", 1, 2)},
+				{after: 1500 * time.Microsecond, msg: ext("a", 1)},
+				{ext: true},
+			},
+			want:    slotStats{Slot: 1, Duration: 2500 * time.Microsecond, Msgs: 4, MaxBN: 2, Rounds: 1, Value: "pizza"},
+			wantCSV: "slot,duration_ms,msgs,max_bn,rounds,value\n1,2.500,4,2,1,pizza\n",
+		},
+		{
+			name: "second round",
+			steps: []step{
+				{nominate: "a"},
+				{after: 3 * scp.NomRoundInterval, msg: prep("a", 1, 1)},
+				{ext: true},
+			},
+			want:    slotStats{Slot: 1, Duration: 3 * scp.NomRoundInterval, Msgs: 1, MaxBN: 1, Rounds: 2, Value: "pizza"},
+			wantCSV: "slot,duration_ms,msgs,max_bn,rounds,value\n1,3000.000,1,1,2,pizza\n",
+		},
+		{
+			// Messages about slots no one has nominated for, or that are
+			// done, are not counted.
+			name: "ignored messages",
+			steps: []step{
+				{msg: nom("a", 1)},
+				{nominate: "a"},
+				{msg: nom("a", 1)},
+				{msg: prep("b", 2, 9)},
+				{after: time.Second, ext: true},
+				{msg: prep("a", 1, 5)},
+			},
+			want:    slotStats{Slot: 1, Duration: time.Second, Msgs: 1, Value: "pizza"},
+			wantCSV: "slot,duration_ms,msgs,max_bn,rounds,value\n1,1000.000,1,0,0,pizza\n",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			now := time.Unix(1000, 0)
+			sc := newStatsCollector(func() time.Time { return now })
+			for _, s := range tc.steps {
+				now = now.Add(s.after)
+				switch {
+				case s.nominate != "":
+					sc.nominated(s.nominate, 1)
+				case s.msg != nil:
+					sc.sent(s.msg)
+				case s.ext:
+					sc.externalized(1, valType("pizza"))
+				}
+			}
+			if len(sc.done) != 1 {
+				t.Fatalf("got %d completed slots, want 1", len(sc.done))
+			}
+			got := *sc.done[0]
+			got.start, got.nomTime, got.balTime = time.Time{}, nil, nil
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %+v, want %+v", got, tc.want)
+			}
+
+			buf := new(bytes.Buffer)
+			if err := sc.writeCSV(buf); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tc.wantCSV {
+				t.Errorf("got CSV %q, want %q", buf, tc.wantCSV)
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bobg/scp"
+	"github.com/bobg/scp/sim"
+)
+
+// A delivery is a message waiting in the step-mode queue.
+type delivery struct {
+	to  scp.NodeID
+	msg *scp.Msg
+}
+
+// A stepper runs a network one user action at a time (with -step).
+// Messages wait in a queue until the user delivers or drops them, and
+// time passes only when the user says so.
+type stepper struct {
+	out     io.Writer
+	conf    *config
+	combine strategy
+	clock   *sim.Clock
+	rng     *rand.Rand
+	ch      chan *scp.Msg
+	nodes   map[scp.NodeID]*scp.Node
+	nodeIDs scp.NodeIDSet
+
+	behaviors map[scp.NodeID]behavior
+	queue     []delivery
+	slotID    scp.SlotID
+}
+
+const stepHelp = `commands:
+  d [N...]   deliver queued messages N... (default: the first one)
+  a          deliver every message now in the queue, in order
+  x N...     drop messages N...
+  dup N      duplicate message N
+  mv N K     move message N to position K
+  t [DUR]    let DUR (default 1s) of time pass, firing any timers
+  q          quit
+`
+
+// Runs the network in step mode, reading commands from in.
+func runStep(conf *config, opts runOpts, in io.Reader, out io.Writer) error {
+	st := &stepper{
+		out:       out,
+		conf:      conf,
+		combine:   opts.combine,
+		clock:     sim.NewClock(),
+		rng:       rand.New(rand.NewSource(opts.seed)),
+		ch:        make(chan *scp.Msg, 4096),
+		nodes:     make(map[scp.NodeID]*scp.Node),
+		behaviors: make(map[scp.NodeID]behavior),
+	}
+	for nodeID, nconf := range conf.nodes {
+		node := scp.NewNode(scp.NodeID(nodeID), nconf.Q, st.ch, nil)
+		node.Clock = st.clock
+		st.nodes[node.ID] = node
+		st.nodeIDs = st.nodeIDs.Add(node.ID)
+	}
+	for _, nodeID := range st.nodeIDs {
+		b, err := newBehavior(conf.nodes[string(nodeID)], st.nodeIDs, opts.combine)
+		if err != nil {
+			return fmt.Errorf("node %s: %s", nodeID, err)
+		}
+		if b != nil {
+			st.behaviors[nodeID] = b
+		}
+	}
+
+	st.nextSlot()
+	st.show()
+
+	fmt.Fprint(out, stepHelp)
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(out, "> ")
+		if !scanner.Scan() {
+			return scanner.Err()
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "q" {
+			return nil
+		}
+		err := st.do(fields[0], fields[1:])
+		if err != nil {
+			fmt.Fprintf(out, "%s\n", err)
+			continue
+		}
+		st.settle()
+		st.show()
+	}
+}
+
+// Performs one user command.
+func (st *stepper) do(cmd string, args []string) error {
+	nums := make([]int, 0, len(args))
+	for _, arg := range args {
+		if cmd == "t" {
+			break
+		}
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 0 || n >= len(st.queue) {
+			return fmt.Errorf("no message %q in the queue", arg)
+		}
+		nums = append(nums, n)
+	}
+
+	switch cmd {
+	case "d":
+		if len(nums) == 0 {
+			if len(st.queue) == 0 {
+				return fmt.Errorf("the queue is empty")
+			}
+			nums = []int{0}
+		}
+		taken := st.take(nums)
+		for _, d := range taken {
+			st.nodes[d.to].Handle(d.msg)
+		}
+
+	case "a":
+		taken := st.queue
+		st.queue = nil
+		for _, d := range taken {
+			st.nodes[d.to].Handle(d.msg)
+		}
+
+	case "x":
+		if len(nums) == 0 {
+			return fmt.Errorf("usage: x N...")
+		}
+		st.take(nums)
+
+	case "dup":
+		if len(nums) != 1 {
+			return fmt.Errorf("usage: dup N")
+		}
+		st.queue = append(st.queue, st.queue[nums[0]])
+
+	case "mv":
+		if len(nums) != 2 {
+			return fmt.Errorf("usage: mv N K")
+		}
+		d := st.take(nums[:1])[0]
+		k := nums[1]
+		if k > len(st.queue) {
+			k = len(st.queue)
+		}
+		st.queue = append(st.queue[:k], append([]delivery{d}, st.queue[k:]...)...)
+
+	case "t":
+		dur := time.Second
+		if len(args) > 0 {
+			var err error
+			dur, err = time.ParseDuration(args[0])
+			if err != nil {
+				return err
+			}
+		}
+		st.clock.Advance(dur)
+
+	default:
+		return fmt.Errorf("unknown command %q\n%s", cmd, stepHelp)
+	}
+	return nil
+}
+
+// Removes the given messages from the queue and returns them, in the
+// order given.
+func (st *stepper) take(nums []int) []delivery {
+	var (
+		result []delivery
+		taken  = make(map[int]bool)
+	)
+	for _, n := range nums {
+		if !taken[n] {
+			result = append(result, st.queue[n])
+			taken[n] = true
+		}
+	}
+	var rest []delivery
+	for i, d := range st.queue {
+		if !taken[i] {
+			rest = append(rest, d)
+		}
+	}
+	st.queue = rest
+	return result
+}
+
+// Lets every node process its pending events, queueing the messages
+// they send, and starts the next slot once all nodes (other than
+// silent ones) have externalized the current one.
+func (st *stepper) settle() {
+	for progress := true; progress; {
+		progress = false
+		for _, nodeID := range st.nodeIDs {
+			for st.nodes[nodeID].Step() {
+				progress = true
+				st.drain()
+			}
+		}
+	}
+	for _, nodeID := range st.nodeIDs {
+		if !st.conf.silent(nodeID) && st.nodes[nodeID].HighestExt() < st.slotID {
+			return
+		}
+	}
+	fmt.Fprintf(st.out, "all externalized slot %d\n", st.slotID)
+	st.nextSlot()
+	st.settle()
+}
+
+// Queues the messages the nodes have sent.
+func (st *stepper) drain() {
+	for len(st.ch) > 0 {
+		msg := <-st.ch
+		b := st.behaviors[msg.V]
+		for _, to := range st.nodeIDs {
+			if to == msg.V {
+				continue
+			}
+			m := msg
+			if b != nil {
+				if m = b.alter(msg, to); m == nil {
+					continue
+				}
+			}
+			st.queue = append(st.queue, delivery{to: to, msg: m})
+		}
+	}
+}
+
+func (st *stepper) nextSlot() {
+	st.slotID++
+	for _, nodeID := range st.nodeIDs {
+		node := st.nodes[nodeID]
+		val := st.combine.value(node.ID, st.conf.nodes[string(node.ID)].ranking(st.rng))
+		node.Handle(scp.NewMsg(node.ID, st.slotID, node.QSetFor(st.slotID), &scp.NomTopic{X: scp.ValueSet{val}}))
+	}
+	st.settle()
+}
+
+// Shows the state of each node in the current slot, and the queue.
+func (st *stepper) show() {
+	fmt.Fprintf(st.out, "\ntime +%s, slot %d\n", st.clock.Now().Sub(sim.Epoch), st.slotID)
+	for _, nodeID := range st.nodeIDs {
+		state := "(no state)"
+		for _, msg := range st.nodes[nodeID].MsgsSince(st.slotID - 1) {
+			if msg != nil && msg.I == st.slotID {
+				state = fmt.Sprintf("%s", msg.T)
+			}
+		}
+		fmt.Fprintf(st.out, "  %-8s %s\n", nodeID, state)
+	}
+	fmt.Fprintf(st.out, "queue (%d):\n", len(st.queue))
+	for i, d := range st.queue {
+		fmt.Fprintf(st.out, "  %3d  %s -> %s  slot %d: %s\n", i, d.msg.V, d.to, d.msg.I, d.msg.T)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bobg/scp"
+	"github.com/bobg/scp/sim"
+)
+
+func TestStepCommands(t *testing.T) {
+	// Each case starts with messages for slots 1 through 4 in the
+	// queue, and the queue afterwards is described by their slots.
+	cases := []struct {
+		cmd       string
+		wantQueue string
+		wantTime  time.Duration
+		wantErr   bool
+	}{
+		{cmd: "d", wantQueue: "2 3 4"},
+		{cmd: "d 2", wantQueue: "1 2 4"},
+		{cmd: "d 3 0 3", wantQueue: "2 3"},
+		{cmd: "d 4", wantErr: true},
+		{cmd: "d -1", wantErr: true},
+		{cmd: "d one", wantErr: true},
+		{cmd: "a", wantQueue: ""},
+		{cmd: "x 1 2", wantQueue: "1 4"},
+		{cmd: "x", wantErr: true},
+		{cmd: "dup 1", wantQueue: "1 2 3 4 2"},
+		{cmd: "dup", wantErr: true},
+		{cmd: "dup 1 2", wantErr: true},
+		{cmd: "mv 3 0", wantQueue: "4 1 2 3"},
+		{cmd: "mv 0 3", wantQueue: "2 3 4 1"},
+		{cmd: "mv 1", wantErr: true},
+		{cmd: "t", wantQueue: "1 2 3 4", wantTime: time.Second},
+		{cmd: "t 250ms", wantQueue: "1 2 3 4", wantTime: 250 * time.Millisecond},
+		{cmd: "t soon", wantErr: true},
+		{cmd: "z", wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.cmd, func(t *testing.T) {
+			conf := testConf(t, "a:1(b) b:1(a)")
+			st := &stepper{
+				conf:  conf,
+				clock: sim.NewClock(),
+				nodes: make(map[scp.NodeID]*scp.Node),
+			}
+			for nodeID, nconf := range conf.nodes {
+				st.nodes[scp.NodeID(nodeID)] = scp.NewNode(scp.NodeID(nodeID), nconf.Q, nil, nil)
+			}
+			for i := scp.SlotID(1); i <= 4; i++ {
+				msg := scp.NewMsg("a", i, conf.nodes["a"].Q, &scp.NomTopic{})
+				st.queue = append(st.queue, delivery{to: "b", msg: msg})
+			}
+
+			fields := strings.Fields(tc.cmd)
+			err := st.do(fields[0], fields[1:])
+			if tc.wantErr {
+				if err == nil {
+					t.Error("got no error")
+				}
+				if len(st.queue) != 4 {
+					t.Errorf("queue has %d messages after error, want 4", len(st.queue))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, d := range st.queue {
+				got = append(got, fmt.Sprint(d.msg.I))
+			}
+			if s := strings.Join(got, " "); s != tc.wantQueue {
+				t.Errorf("got queue %q, want %q", s, tc.wantQueue)
+			}
+			if got := st.clock.Now().Sub(sim.Epoch); got != tc.wantTime {
+				t.Errorf("got time +%s, want +%s", got, tc.wantTime)
+			}
+		})
+	}
+}
+
+func TestRunStep(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		want  []string // substrings of the output
+	}{
+		{name: "quit", input: "q\n", want: []string{"slot 1", "queue (1):"}},
+		{name: "eof", input: "", want: []string{"queue (1):"}},
+		{name: "blank line", input: "\n\nq\n", want: []string{"queue (1):"}},
+		{name: "bad command", input: "bogus\nq\n", want: []string{`unknown command "bogus"`}},
+		{name: "bad message", input: "d 9\nq\n", want: []string{`no message "9" in the queue`}},
+		{name: "deliver all", input: strings.Repeat("a\n", 20) + "q\n", want: []string{"all externalized slot 1", "slot 2"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			conf := testConf(t, "a:1(b) b:1(a)")
+			out := new(strings.Builder)
+			if err := runStep(conf, runOpts{combine: combineParity, seed: 1}, strings.NewReader(tc.input), out); err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range tc.want {
+				if !strings.Contains(out.String(), s) {
+					t.Errorf("output does not contain %q:\n%s", s, out)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+
+	"github.com/bobg/scp"
+)
+
+// The largest network for which disjointQuorums searches exhaustively
+// for disjoint quorums.
+const maxExhaustive = 16
+
+// Checks that every node's QSet is well formed and names only
+// configured nodes, and that each node's preferences make sense. A
+// silent node's QSet is never used, so it may be empty.
+func (conf *config) validate() error {
+	var names []string
+	for name := range conf.nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		q := conf.nodes[name].Q
+		if !conf.silent(scp.NodeID(name)) || q.T != 0 || len(q.M) != 0 {
+			err := conf.validateQSet(name, q)
+			if err != nil {
+				return fmt.Errorf("node %s: %s", name, err)
+			}
+		}
+		err := conf.nodes[name].validatePrefs()
+		if err != nil {
+			return fmt.Errorf("node %s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// Checks the QSet of the named node the way scp.Node.SetQSet does:
+// every threshold must be between 1 and the number of members, and no
+// node may appear more than once or name itself. Every node named must
+// also be configured.
+func (conf *config) validateQSet(self string, q scp.QSet) error {
+	seen := make(map[scp.NodeID]bool)
+	var check func(scp.QSet) error
+	check = func(q scp.QSet) error {
+		if q.T < 1 || q.T > len(q.M) {
+			return fmt.Errorf("threshold %d is not between 1 and the number of members, %d", q.T, len(q.M))
+		}
+		for _, m := range q.M {
+			switch {
+			case m.N != nil && m.Q != nil:
+				return fmt.Errorf("member is both a node and a QSet")
+			case m.N != nil:
+				if string(*m.N) == self {
+					return fmt.Errorf("QSet includes the node itself")
+				}
+				if seen[*m.N] {
+					return fmt.Errorf("node %q appears more than once", *m.N)
+				}
+				seen[*m.N] = true
+				if _, ok := conf.nodes[string(*m.N)]; !ok {
+					return fmt.Errorf("unknown node %q", *m.N)
+				}
+			case m.Q != nil:
+				if err := check(*m.Q); err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("empty member")
+			}
+		}
+		return nil
+	}
+	return check(q)
+}
+
+// A topology is the quorum structure of a configured network.
+type topology struct {
+	nodeIDs scp.NodeIDSet
+	qsets   map[scp.NodeID]scp.QSet
+}
+
+// Produces the topology of conf. Silent nodes are left out, since
+// they can contribute to no quorum.
+func (conf *config) topology() *topology {
+	t := &topology{qsets: make(map[scp.NodeID]scp.QSet)}
+	for name, nconf := range conf.nodes {
+		id := scp.NodeID(name)
+		if conf.silent(id) {
+			continue
+		}
+		t.nodeIDs = t.nodeIDs.Add(id)
+		t.qsets[id] = nconf.Q
+	}
+	return t
+}
+
+// Tells whether q has a slice within s.
+func satisfied(q scp.QSet, s map[scp.NodeID]bool) bool {
+	if q.T <= 0 {
+		return true
+	}
+	n := 0
+	for _, m := range q.M {
+		if (m.N != nil && s[*m.N]) || (m.Q != nil && satisfied(*m.Q, s)) {
+			n++
+			if n >= q.T {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Finds the largest quorum within the given set of nodes, which is
+// the union of all quorums within it. The result is empty if there is
+// none.
+func (t *topology) maxQuorum(nodeIDs scp.NodeIDSet) map[scp.NodeID]bool {
+	s := make(map[scp.NodeID]bool)
+	for _, id := range nodeIDs {
+		s[id] = true
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, id := range nodeIDs {
+			if s[id] && !satisfied(t.qsets[id], s) {
+				delete(s, id)
+				changed = true
+			}
+		}
+	}
+	return s
+}
+
+// Finds a minimal quorum containing the given node, if there is one,
+// trying to drop other nodes in the given order.
+func (t *topology) minQuorum(id scp.NodeID, order scp.NodeIDSet) scp.NodeIDSet {
+	q := t.maxQuorum(t.nodeIDs)
+	if !q[id] {
+		return nil
+	}
+	for _, other := range order {
+		if other == id || !q[other] {
+			continue
+		}
+		var rest scp.NodeIDSet
+		for n := range q {
+			if n != other {
+				rest = rest.Add(n)
+			}
+		}
+		if q2 := t.maxQuorum(rest); q2[id] {
+			q = q2
+		}
+	}
+	return setToList(q)
+}
+
+// Finds a small blocking set for q: a set of nodes intersecting every
+// slice of q (not counting the node that q belongs to). It is made of
+// the len(q.M)-q.T+1 members that are cheapest to block, a nested
+// member being blocked by a blocking set of its own.
+func blockingSet(q scp.QSet) scp.NodeIDSet {
+	if q.T <= 0 {
+		return nil
+	}
+	var options []scp.NodeIDSet
+	for _, m := range q.M {
+		if m.N != nil {
+			options = append(options, scp.NodeIDSet{*m.N})
+		} else {
+			options = append(options, blockingSet(*m.Q))
+		}
+	}
+	sort.SliceStable(options, func(i, j int) bool { return len(options[i]) < len(options[j]) })
+	var result scp.NodeIDSet
+	for _, option := range options[:len(q.M)-q.T+1] {
+		result = result.Union(option)
+	}
+	return result
+}
+
+func setToList(s map[scp.NodeID]bool) scp.NodeIDSet {
+	var result scp.NodeIDSet
+	for id := range s {
+		result = result.Add(id)
+	}
+	return result
+}
+
+// Finds two disjoint quorums, if possible. For networks larger than
+// maxExhaustive it tries only a sample of candidates, and the boolean
+// result tells whether the search was exhaustive.
+func (t *topology) disjointQuorums() (a, b scp.NodeIDSet, exhaustive bool) {
+	n := len(t.nodeIDs)
+	if n <= maxExhaustive {
+		// Every quorum contains a quorum of the form maxQuorum(S), so it
+		// suffices to check, for each S (containing the first node,
+		// without loss of generality), whether both S and its complement
+		// contain quorums.
+		for bits := 1; bits < 1<<uint(n); bits += 2 {
+			var s, comp scp.NodeIDSet
+			for i, id := range t.nodeIDs {
+				if bits&(1<<uint(i)) != 0 {
+					s = append(s, id)
+				} else {
+					comp = append(comp, id)
+				}
+			}
+			qa := t.maxQuorum(s)
+			if len(qa) == 0 {
+				continue
+			}
+			if qb := t.maxQuorum(comp); len(qb) > 0 {
+				return setToList(qa), setToList(qb), true
+			}
+		}
+		return nil, nil, true
+	}
+
+	// Find some minimal quorums and check whether any of them leaves
+	// room for another quorum among the remaining nodes.
+	rng := rand.New(rand.NewSource(1))
+	orders := []scp.NodeIDSet{t.nodeIDs}
+	for i := 0; i < 4; i++ {
+		order := append(scp.NodeIDSet(nil), t.nodeIDs...)
+		rng.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+		orders = append(orders, order)
+	}
+	for _, id := range t.nodeIDs {
+		for _, order := range orders {
+			q := t.minQuorum(id, order)
+			if len(q) == 0 {
+				break
+			}
+			var rest scp.NodeIDSet
+			for _, other := range t.nodeIDs {
+				if !q.Contains(other) {
+					rest = append(rest, other)
+				}
+			}
+			if qb := t.maxQuorum(rest); len(qb) > 0 {
+				return q, setToList(qb), false
+			}
+		}
+	}
+	return nil, nil, false
+}
+
+// Produces warnings about the quorum structure of the network: nodes
+// that belong to no quorum (and so can never externalize anything),
+// and quorums that do not intersect (allowing the network to
+// externalize conflicting values).
+func (t *topology) warnings() []string {
+	var result []string
+
+	all := t.maxQuorum(t.nodeIDs)
+	if len(all) == 0 {
+		return []string{"the network contains no quorum"}
+	}
+	for _, id := range t.nodeIDs {
+		if !all[id] {
+			result = append(result, fmt.Sprintf("node %s belongs to no quorum and can never externalize a value", id))
+		}
+	}
+
+	a, b, exhaustive := t.disjointQuorums()
+	if len(a) > 0 {
+		result = append(result, fmt.Sprintf("disjoint quorums %v and %v may externalize different values", a, b))
+	} else if !exhaustive {
+		result = append(result, fmt.Sprintf("no disjoint quorums found, but the search for them was not exhaustive (more than %d nodes)", maxExhaustive))
+	}
+	return result
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bobg/scp"
+)
+
+// Builds a config from a compact description such as "a:2(b c d)
+// b:1(a) c:2(a 1(b d))", in which each node is followed by its
+// threshold and members, a nested QSet being written the same way
+// without a node name. Whitespace separates nodes and members.
+func testConf(t *testing.T, desc string) *config {
+	t.Helper()
+	conf := newGenConf()
+	for _, field := range splitTop(desc) {
+		parts := strings.SplitN(field, ":", 2)
+		if len(parts) != 2 {
+			t.Fatalf("bad node %q", field)
+		}
+		q, err := parseTestQSet(parts[1])
+		if err != nil {
+			t.Fatalf("node %s: %s", parts[0], err)
+		}
+		conf.nodes[parts[0]] = nodeconf{Q: q}
+	}
+	return conf
+}
+
+// Splits s at spaces that are outside parentheses.
+func splitTop(s string) []string {
+	var (
+		result []string
+		depth  int
+		start  = -1
+	)
+	for i, c := range s {
+		switch {
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ' ' && depth == 0:
+			if start >= 0 {
+				result = append(result, s[start:i])
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		result = append(result, s[start:])
+	}
+	return result
+}
+
+func parseTestQSet(s string) (scp.QSet, error) {
+	open := strings.Index(s, "(")
+	if open < 0 || !strings.HasSuffix(s, ")") {
+		return scp.QSet{}, fmt.Errorf("bad QSet %q", s)
+	}
+	var q scp.QSet
+	if _, err := fmt.Sscanf(s[:open], "%d", &q.T); err != nil {
+		return scp.QSet{}, fmt.Errorf("bad threshold in %q", s)
+	}
+	for _, member := range splitTop(s[open+1 : len(s)-1]) {
+		if strings.Contains(member, "(") {
+			inner, err := parseTestQSet(member)
+			if err != nil {
+				return scp.QSet{}, err
+			}
+			q.M = append(q.M, scp.QSetMember{Q: &inner})
+			continue
+		}
+		id := scp.NodeID(member)
+		q.M = append(q.M, scp.QSetMember{N: &id})
+	}
+	return q, nil
+}
+
+func ids(s string) scp.NodeIDSet {
+	var result scp.NodeIDSet
+	for _, f := range strings.Fields(s) {
+		result = result.Add(scp.NodeID(f))
+	}
+	return result
+}
+
+func TestMaxQuorum(t *testing.T) {
+	cases := []struct {
+		conf  string
+		nodes string // the set to search
+		want  string
+	}{
+		{conf: "a:1(b) b:1(a)", nodes: "a b", want: "a b"},
+		{conf: "a:1(b) b:1(a)", nodes: "a", want: ""},
+		{conf: "a:2(b c) b:2(a c) c:2(a b)", nodes: "a b c", want: "a b c"},
+		{conf: "a:2(b c) b:2(a c) c:2(a b)", nodes: "a b", want: ""},
+		// D trusts only nodes that do not trust it back, and is still
+		// in the quorum.
+		{conf: "a:1(b) b:1(a) d:2(a b)", nodes: "a b d", want: "a b d"},
+		// Dropping c cascades to b and then a.
+		{conf: "a:1(b) b:1(c) c:1(d) d:1(c)", nodes: "a b c", want: ""},
+		{conf: "a:1(b) b:1(c) c:1(d) d:1(c)", nodes: "a b c d", want: "a b c d"},
+		{conf: "a:1(1(b c)) b:0() c:1(a)", nodes: "a c", want: "a c"},
+		{conf: "a:1(2(b c)) b:0() c:1(a)", nodes: "a c", want: ""},
+		{conf: "a:0()", nodes: "a", want: "a"},
+	}
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%02d", i+1), func(t *testing.T) {
+			top := testConf(t, tc.conf).topology()
+			got := setToList(top.maxQuorum(ids(tc.nodes)))
+			if want := ids(tc.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestBlockingSet(t *testing.T) {
+	cases := []struct {
+		q    string
+		want string
+	}{
+		{q: "0()", want: ""},
+		{q: "1(a)", want: "a"},
+		{q: "1(a b c)", want: "a b c"},
+		{q: "2(a b c)", want: "a b"},
+		{q: "3(a b c)", want: "a"},
+		// The cheapest nested members.
+		{q: "1(3(a b c) 1(d e))", want: "a d e"},
+		{q: "2(1(a b) x 2(c d e))", want: "a b x"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.q, func(t *testing.T) {
+			q, err := parseTestQSet(tc.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := blockingSet(q)
+			if want := ids(tc.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestDisjointQuorums(t *testing.T) {
+	cases := []struct {
+		name       string
+		conf       *config
+		disjoint   bool
+		exhaustive bool
+	}{
+		{
+			name:       "triangle",
+			conf:       testConf(t, "a:2(b c) b:2(a c) c:2(a b)"),
+			exhaustive: true,
+		},
+		{
+			name:       "pairs",
+			conf:       testConf(t, "a:1(b) b:1(a) c:1(d) d:1(c)"),
+			disjoint:   true,
+			exhaustive: true,
+		},
+		{
+			name:       "one of three",
+			conf:       testConf(t, "a:1(b c) b:1(a c) c:1(a b)"),
+			disjoint:   false,
+			exhaustive: true,
+		},
+		{
+			name:       "one of four",
+			conf:       testConf(t, "a:1(b c d) b:1(a c d) c:1(a b d) d:1(a b c)"),
+			disjoint:   true,
+			exhaustive: true,
+		},
+		{
+			name:       "tiered",
+			conf:       mustGenerate(t, "tiered:4,4,2"),
+			exhaustive: true,
+		},
+		{
+			name: "orgs",
+			conf: mustGenerate(t, "orgs:7x3"),
+		},
+		{
+			name:     "orgs at 30%",
+			conf:     mustGenerate(t, "orgs:7x3@30%"),
+			disjoint: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			top := tc.conf.topology()
+			a, b, exhaustive := top.disjointQuorums()
+			if exhaustive != tc.exhaustive {
+				t.Errorf("exhaustive is %v, want %v", exhaustive, tc.exhaustive)
+			}
+			if got := len(a) > 0; got != tc.disjoint {
+				t.Fatalf("found disjoint quorums %v and %v, want %v", a, b, tc.disjoint)
+			}
+			if !tc.disjoint {
+				return
+			}
+			for _, id := range a {
+				if b.Contains(id) {
+					t.Errorf("quorums %v and %v share %s", a, b, id)
+				}
+			}
+			for _, q := range []scp.NodeIDSet{a, b} {
+				if got := setToList(top.maxQuorum(q)); !reflect.DeepEqual(got, q) {
+					t.Errorf("%v is not a quorum", q)
+				}
+			}
+		})
+	}
+}
+
+func mustGenerate(t *testing.T, spec string) *config {
+	t.Helper()
+	conf, err := generate(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conf
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name    string
+		conf    string
+		silent  string // nodes to make silent
+		wantErr bool
+	}{
+		{name: "ok", conf: "a:1(b) b:1(a)"},
+		{name: "nested", conf: "a:1(b 1(c)) b:1(a) c:1(a)"},
+		{name: "self", conf: "a:1(a b) b:1(a)", wantErr: true},
+		{name: "nested self", conf: "a:1(b 1(a c)) b:1(a) c:1(a)", wantErr: true},
+		{name: "duplicate", conf: "a:2(b b) b:1(a)", wantErr: true},
+		{name: "nested duplicate", conf: "a:2(b 1(b c)) b:1(a) c:1(a)", wantErr: true},
+		{name: "empty", conf: "a:1(b) b:0()", wantErr: true},
+		{name: "empty silent", conf: "a:1(b) b:0()", silent: "b"},
+		{name: "zero threshold", conf: "a:0(b) b:1(a)", wantErr: true},
+		{name: "high threshold", conf: "a:2(b) b:1(a)", wantErr: true},
+		{name: "nested zero threshold", conf: "a:1(b 0(c)) b:1(a) c:1(a)", wantErr: true},
+		{name: "unknown", conf: "a:1(b z) b:1(a)", wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			conf := testConf(t, tc.conf)
+			for _, id := range ids(tc.silent) {
+				conf.nodes[string(id)] = nodeconf{Q: conf.nodes[string(id)].Q, Behavior: behaviorSilent}
+			}
+			err := conf.validate()
+			if tc.wantErr && err == nil {
+				t.Error("got no error, want one")
+			} else if !tc.wantErr && err != nil {
+				t.Errorf("got error %s, want none", err)
+			}
+		})
+	}
+}
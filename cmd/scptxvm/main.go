@@ -10,6 +10,7 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"os"
 	"path"
 	"sync"
 	"time"
@@ -49,10 +50,12 @@ func main() {
 	var (
 		confFile         string
 		initialBlockFile string
+		traceFile        string
 	)
 	flag.StringVar(&dir, "dir", ".", "root of working dir")
 	flag.StringVar(&confFile, "conf", "", "config file (default is conf.toml in root of working dir)")
 	flag.StringVar(&initialBlockFile, "initial", "", "file containing initial block (default is blocks/1 under working dir)")
+	flag.StringVar(&traceFile, "trace", "", "file in which to record a trace of SCP activity (for use with scp.Replay)")
 
 	flag.Parse()
 
@@ -130,6 +133,15 @@ func main() {
 	nodeID := fmt.Sprintf("http://%s/%s", conf.Addr, pubKeyHex)
 	node = scp.NewNode(scp.NodeID(nodeID), conf.Q, msgChan, ext)
 
+	if traceFile != "" {
+		f, err := os.Create(traceFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		node.Trace(f)
+	}
+
 	go func() {
 		node.Run(bgctx)
 		wg.Done()
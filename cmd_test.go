@@ -0,0 +1,33 @@
+package scp
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCmdChanCancel(t *testing.T) {
+	c := newCmdChan()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		if _, ok := c.read(ctx); ok {
+			t.Error("read returned a command from an empty queue")
+		}
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	<-done
+
+	// The queue still works after a canceled read.
+	c.write(&msgCmd{})
+	cmd, ok := c.read(context.Background())
+	if !ok {
+		t.Fatal("read failed after write")
+	}
+	if _, ok := cmd.(*msgCmd); !ok {
+		t.Errorf("read %T, want *msgCmd", cmd)
+	}
+}
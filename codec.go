@@ -0,0 +1,231 @@
+package scp
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ValueDecoder reconstructs a Value from the output of its Bytes
+// method.
+type ValueDecoder func([]byte) (Value, error)
+
+// MarshalMsg produces a JSON encoding of a message. Values are encoded
+// using their Bytes methods.
+func MarshalMsg(msg *Msg) ([]byte, error) {
+	return json.Marshal(encodeMsg(msg))
+}
+
+// UnmarshalMsg decodes a message produced by MarshalMsg, using dec to
+// reconstruct its values.
+func UnmarshalMsg(b []byte, dec ValueDecoder) (*Msg, error) {
+	var jm jsonMsg
+	err := json.Unmarshal(b, &jm)
+	if err != nil {
+		return nil, err
+	}
+	return jm.decode(dec)
+}
+
+type (
+	jsonMsg struct {
+		C int32     `json:"c"`
+		V NodeID    `json:"v"`
+		I SlotID    `json:"i"`
+		Q QSet      `json:"q"`
+		T jsonTopic `json:"t"`
+	}
+
+	jsonTopic struct {
+		Type string      `json:"type"`
+		X    [][]byte    `json:"x,omitempty"`
+		Y    [][]byte    `json:"y,omitempty"`
+		B    *jsonBallot `json:"b,omitempty"`
+		P    *jsonBallot `json:"p,omitempty"`
+		PP   *jsonBallot `json:"pp,omitempty"`
+		C    *jsonBallot `json:"c,omitempty"`
+		PN   int         `json:"pn,omitempty"`
+		HN   int         `json:"hn,omitempty"`
+		CN   int         `json:"cn,omitempty"`
+	}
+
+	jsonBallot struct {
+		N int    `json:"n"`
+		X []byte `json:"x"`
+	}
+)
+
+// Names for the topic types in the JSON encoding.
+const (
+	jsonNom     = "NOM"
+	jsonNomPrep = "NOM/PREP"
+	jsonPrep    = "PREP"
+	jsonCommit  = "COMMIT"
+	jsonExt     = "EXT"
+)
+
+func encodeMsg(msg *Msg) *jsonMsg {
+	return &jsonMsg{
+		C: msg.C,
+		V: msg.V,
+		I: msg.I,
+		Q: msg.Q,
+		T: encodeTopic(msg.T),
+	}
+}
+
+func encodeTopic(t Topic) jsonTopic {
+	var jt jsonTopic
+	prep := func(topic *PrepTopic) {
+		jt.B = encodeBallot(topic.B)
+		jt.P = encodeBallot(topic.P)
+		jt.PP = encodeBallot(topic.PP)
+		jt.HN = topic.HN
+		jt.CN = topic.CN
+	}
+	switch topic := t.(type) {
+	case *NomTopic:
+		jt.Type = jsonNom
+		jt.X = encodeValueSet(topic.X)
+		jt.Y = encodeValueSet(topic.Y)
+
+	case *NomPrepTopic:
+		jt.Type = jsonNomPrep
+		jt.X = encodeValueSet(topic.X)
+		jt.Y = encodeValueSet(topic.Y)
+		prep(&topic.PrepTopic)
+
+	case *PrepTopic:
+		jt.Type = jsonPrep
+		prep(topic)
+
+	case *CommitTopic:
+		jt.Type = jsonCommit
+		jt.B = encodeBallot(topic.B)
+		jt.PN = topic.PN
+		jt.HN = topic.HN
+		jt.CN = topic.CN
+
+	case *ExtTopic:
+		jt.Type = jsonExt
+		jt.C = encodeBallot(topic.C)
+		jt.HN = topic.HN
+	}
+	return jt
+}
+
+func encodeValue(v Value) []byte {
+	if v == nil {
+		return nil
+	}
+	return v.Bytes()
+}
+
+func encodeValueSet(vs ValueSet) [][]byte {
+	var result [][]byte
+	for _, v := range vs {
+		result = append(result, encodeValue(v))
+	}
+	return result
+}
+
+func encodeBallot(b Ballot) *jsonBallot {
+	if b.IsZero() {
+		return nil
+	}
+	return &jsonBallot{N: b.N, X: encodeValue(b.X)}
+}
+
+func (jm *jsonMsg) decode(dec ValueDecoder) (*Msg, error) {
+	t, err := jm.T.decode(dec)
+	if err != nil {
+		return nil, err
+	}
+	return &Msg{
+		C: jm.C,
+		V: jm.V,
+		I: jm.I,
+		Q: jm.Q,
+		T: t,
+	}, nil
+}
+
+func (jt jsonTopic) decode(dec ValueDecoder) (Topic, error) {
+	var (
+		nom  NomTopic
+		prep PrepTopic
+		err  error
+	)
+	decodeNom := func() {
+		if nom.X, err = decodeValueSet(jt.X, dec); err != nil {
+			return
+		}
+		nom.Y, err = decodeValueSet(jt.Y, dec)
+	}
+	decodePrep := func() {
+		if prep.B, err = jt.B.decode(dec); err != nil {
+			return
+		}
+		if prep.P, err = jt.P.decode(dec); err != nil {
+			return
+		}
+		if prep.PP, err = jt.PP.decode(dec); err != nil {
+			return
+		}
+		prep.HN = jt.HN
+		prep.CN = jt.CN
+	}
+
+	switch jt.Type {
+	case jsonNom:
+		decodeNom()
+		return &nom, err
+
+	case jsonNomPrep:
+		decodeNom()
+		if err != nil {
+			return nil, err
+		}
+		decodePrep()
+		return &NomPrepTopic{NomTopic: nom, PrepTopic: prep}, err
+
+	case jsonPrep:
+		decodePrep()
+		return &prep, err
+
+	case jsonCommit:
+		b, err := jt.B.decode(dec)
+		return &CommitTopic{B: b, PN: jt.PN, HN: jt.HN, CN: jt.CN}, err
+
+	case jsonExt:
+		c, err := jt.C.decode(dec)
+		return &ExtTopic{C: c, HN: jt.HN}, err
+	}
+	return nil, fmt.Errorf("unknown topic type %q", jt.Type)
+}
+
+func decodeValue(b []byte, dec ValueDecoder) (Value, error) {
+	if b == nil {
+		return nil, nil
+	}
+	return dec(b)
+}
+
+func decodeValueSet(bs [][]byte, dec ValueDecoder) (ValueSet, error) {
+	var result ValueSet
+	for _, b := range bs {
+		v, err := decodeValue(b, dec)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, v)
+	}
+	return result, nil
+}
+
+func (jb *jsonBallot) decode(dec ValueDecoder) (Ballot, error) {
+	if jb == nil {
+		return ZeroBallot, nil
+	}
+	v, err := decodeValue(jb.X, dec)
+	return Ballot{N: jb.N, X: v}, err
+}
@@ -21,5 +21,9 @@ A toy demo can be found in cmd/lunch. It takes the name of a TOML file
 as an argument. The TOML file specifies the network participants and
 topology. Sample TOML files are in cmd/lunch/toml.
 
+Package sim runs many nodes deterministically on virtual time, and
+package fault injects network faults between a transport and its
+nodes.
+
 */
 package scp
@@ -0,0 +1,53 @@
+package fault
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Dist is a distribution of message latencies.
+type Dist interface {
+	Sample(*rand.Rand) time.Duration
+}
+
+// Fixed is a Dist that always produces the same latency.
+type Fixed time.Duration
+
+func (f Fixed) Sample(*rand.Rand) time.Duration { return time.Duration(f) }
+
+// Uniform is a Dist producing latencies uniformly distributed in
+// [Min,Max).
+type Uniform struct {
+	Min, Max time.Duration
+}
+
+func (u Uniform) Sample(r *rand.Rand) time.Duration {
+	if u.Max <= u.Min {
+		return u.Min
+	}
+	return u.Min + time.Duration(r.Int63n(int64(u.Max-u.Min)))
+}
+
+// Exp is a Dist producing latencies of Min plus an exponentially
+// distributed amount with the given mean.
+type Exp struct {
+	Min, Mean time.Duration
+}
+
+func (e Exp) Sample(r *rand.Rand) time.Duration {
+	return e.Min + time.Duration(r.ExpFloat64()*float64(e.Mean))
+}
+
+// Normal is a Dist producing normally distributed latencies, clamped
+// at zero.
+type Normal struct {
+	Mean, StdDev time.Duration
+}
+
+func (n Normal) Sample(r *rand.Rand) time.Duration {
+	d := n.Mean + time.Duration(r.NormFloat64()*float64(n.StdDev))
+	if d < 0 {
+		return 0
+	}
+	return d
+}
@@ -0,0 +1,265 @@
+// Package fault injects network faults between an SCP transport and
+// the nodes it delivers messages to.
+//
+// An Injector stands in for direct calls to Node.Handle. Each message
+// passed to Injector.Send travels over a Link from its sender to its
+// recipient, where it may be dropped, delayed, duplicated, or
+// reordered. Scheduled Partitions can additionally cut off groups of
+// nodes from one another for a period of time or a range of slots.
+package fault
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/bobg/scp"
+)
+
+// Handler is the type of a message recipient, such as *scp.Node.
+type Handler interface {
+	Handle(*scp.Msg)
+}
+
+// Link describes the faults applied to messages traveling from one
+// node to another.
+type Link struct {
+	// Drop is the probability that a message is lost.
+	Drop float64
+
+	// Latency is the distribution of each message's delivery delay.
+	// Nil means immediate delivery.
+	Latency Dist
+
+	// Dup is the probability that a message is delivered twice (with
+	// independently sampled latencies).
+	Dup float64
+
+	// Reorder is the probability that a message is held back by an
+	// extra delay drawn from ReorderDelay, allowing later messages on
+	// the same link to overtake it.
+	Reorder      float64
+	ReorderDelay Dist
+}
+
+// Partition is a scheduled network split.
+// While it is in effect,
+// nodes in different groups cannot exchange messages.
+// Nodes not listed in any group are unaffected.
+type Partition struct {
+	Groups [][]scp.NodeID
+
+	// Start and End bound the partition in time,
+	// measured from the creation of the Injector.
+	// A zero End means the partition never heals.
+	Start, End time.Duration
+
+	// FromSlot and ToSlot (inclusive) bound the partition by the
+	// slot ID of the messages it affects.
+	// Zero means unbounded.
+	FromSlot, ToSlot scp.SlotID
+}
+
+// Active tells whether p is in effect for messages about the given
+// slot at the given time, measured from the creation of the Injector.
+func (p Partition) Active(elapsed time.Duration, slotID scp.SlotID) bool {
+	if elapsed < p.Start || (p.End > 0 && elapsed >= p.End) {
+		return false
+	}
+	if p.FromSlot > 0 && slotID < p.FromSlot {
+		return false
+	}
+	if p.ToSlot > 0 && slotID > p.ToSlot {
+		return false
+	}
+	return true
+}
+
+// Separates tells whether p, if active, prevents messages between
+// nodes a and b.
+func (p Partition) Separates(a, b scp.NodeID) bool {
+	ga, gb := p.group(a), p.group(b)
+	return ga >= 0 && gb >= 0 && ga != gb
+}
+
+func (p Partition) group(id scp.NodeID) int {
+	for i, g := range p.Groups {
+		for _, member := range g {
+			if member == id {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// Injector delivers messages to a set of Handlers, applying the
+// configured faults along the way. It is safe for concurrent use.
+type Injector struct {
+	mu sync.Mutex
+
+	clock      scp.Clock
+	rng        *rand.Rand
+	start      time.Time
+	handlers   map[scp.NodeID]Handler
+	links      map[[2]scp.NodeID]Link
+	defLink    Link
+	partitions []Partition
+}
+
+// New produces a new Injector with no faults configured. Delayed
+// messages are scheduled with the given clock; nil means
+// scp.RealClock, but a simulated clock may be used instead. Random
+// choices are drawn from a source seeded with seed.
+func New(clock scp.Clock, seed int64) *Injector {
+	if clock == nil {
+		clock = scp.RealClock
+	}
+	return &Injector{
+		clock:    clock,
+		rng:      rand.New(rand.NewSource(seed)),
+		start:    clock.Now(),
+		handlers: make(map[scp.NodeID]Handler),
+		links:    make(map[[2]scp.NodeID]Link),
+	}
+}
+
+// Add registers the Handler for messages addressed to the given node.
+func (inj *Injector) Add(id scp.NodeID, h Handler) {
+	inj.mu.Lock()
+	inj.handlers[id] = h
+	inj.mu.Unlock()
+}
+
+// SetDefault sets the Link used between any two nodes that have no
+// more specific Link configured with SetLink.
+func (inj *Injector) SetDefault(l Link) {
+	inj.mu.Lock()
+	inj.defLink = l
+	inj.mu.Unlock()
+}
+
+// SetLink sets the Link for messages from one node to another.
+func (inj *Injector) SetLink(from, to scp.NodeID, l Link) {
+	inj.mu.Lock()
+	inj.links[[2]scp.NodeID{from, to}] = l
+	inj.mu.Unlock()
+}
+
+// Link returns the Link in effect for messages from one node to
+// another.
+func (inj *Injector) Link(from, to scp.NodeID) Link {
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+	return inj.link(from, to)
+}
+
+func (inj *Injector) link(from, to scp.NodeID) Link {
+	if l, ok := inj.links[[2]scp.NodeID{from, to}]; ok {
+		return l
+	}
+	return inj.defLink
+}
+
+// Elapsed tells the time since the creation of the Injector, against
+// which partitions are scheduled.
+func (inj *Injector) Elapsed() time.Duration {
+	return inj.clock.Now().Sub(inj.start)
+}
+
+// Schedule adds a partition.
+func (inj *Injector) Schedule(p Partition) {
+	inj.mu.Lock()
+	inj.partitions = append(inj.partitions, p)
+	inj.mu.Unlock()
+}
+
+// Partitioned tells whether a message for the given slot from one
+// node to another would currently be blocked by a partition.
+func (inj *Injector) Partitioned(from, to scp.NodeID, slotID scp.SlotID) bool {
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+	return inj.partitioned(from, to, slotID)
+}
+
+func (inj *Injector) partitioned(from, to scp.NodeID, slotID scp.SlotID) bool {
+	elapsed := inj.clock.Now().Sub(inj.start)
+	for _, p := range inj.partitions {
+		if p.Active(elapsed, slotID) && p.Separates(from, to) {
+			return true
+		}
+	}
+	return false
+}
+
+// Send delivers msg from its sender (msg.V) to the node with the given
+// ID, subject to the faults configured for that link. It reports
+// whether the message was delivered or scheduled for delivery (rather
+// than dropped).
+func (inj *Injector) Send(to scp.NodeID, msg *scp.Msg) bool {
+	inj.mu.Lock()
+
+	h, ok := inj.handlers[to]
+	if !ok {
+		inj.mu.Unlock()
+		return false
+	}
+	if msg.V == to {
+		// Self messages are never subject to faults.
+		inj.mu.Unlock()
+		h.Handle(msg)
+		return true
+	}
+	if inj.partitioned(msg.V, to, msg.I) {
+		inj.mu.Unlock()
+		return false
+	}
+
+	l := inj.link(msg.V, to)
+	if l.Drop > 0 && inj.rng.Float64() < l.Drop {
+		inj.mu.Unlock()
+		return false
+	}
+	n := 1
+	if l.Dup > 0 && inj.rng.Float64() < l.Dup {
+		n = 2
+	}
+	delays := make([]time.Duration, 0, n)
+	for i := 0; i < n; i++ {
+		var d time.Duration
+		if l.Latency != nil {
+			d = l.Latency.Sample(inj.rng)
+		}
+		if l.Reorder > 0 && l.ReorderDelay != nil && inj.rng.Float64() < l.Reorder {
+			d += l.ReorderDelay.Sample(inj.rng)
+		}
+		delays = append(delays, d)
+	}
+
+	inj.mu.Unlock()
+
+	for _, d := range delays {
+		if d <= 0 {
+			h.Handle(msg)
+			continue
+		}
+		inj.clock.AfterFunc(d, func() { h.Handle(msg) })
+	}
+	return true
+}
+
+// Broadcast sends msg to every registered node other than its sender.
+func (inj *Injector) Broadcast(msg *scp.Msg) {
+	inj.mu.Lock()
+	var ids scp.NodeIDSet // sorted, for reproducible use of the random source
+	for id := range inj.handlers {
+		if id != msg.V {
+			ids = ids.Add(id)
+		}
+	}
+	inj.mu.Unlock()
+
+	for _, id := range ids {
+		inj.Send(id, msg)
+	}
+}
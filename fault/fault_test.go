@@ -0,0 +1,151 @@
+package fault
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/bobg/scp"
+)
+
+type fakeClock struct {
+	now    time.Time
+	timers []fakeTimer
+}
+
+type fakeTimer struct {
+	at time.Time
+	f  func()
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) scp.Timer {
+	c.timers = append(c.timers, fakeTimer{at: c.now.Add(d), f: f})
+	return nil
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+	sort.SliceStable(c.timers, func(i, j int) bool { return c.timers[i].at.Before(c.timers[j].at) })
+	for len(c.timers) > 0 && !c.timers[0].at.After(c.now) {
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		t.f()
+	}
+}
+
+type recorder []*scp.Msg
+
+func (r *recorder) Handle(msg *scp.Msg) { *r = append(*r, msg) }
+
+func TestSend(t *testing.T) {
+	cases := []struct {
+		link       Link
+		partition  *Partition
+		slotID     scp.SlotID
+		wantOK     bool
+		wantNow    int
+		wantLater  int
+		advanceFor time.Duration
+	}{
+		{
+			wantOK:  true,
+			wantNow: 1,
+		},
+		{
+			link: Link{Drop: 1},
+		},
+		{
+			link:    Link{Dup: 1},
+			wantOK:  true,
+			wantNow: 2,
+		},
+		{
+			link:       Link{Latency: Fixed(time.Second)},
+			wantOK:     true,
+			wantLater:  1,
+			advanceFor: time.Second,
+		},
+		{
+			link:       Link{Reorder: 1, ReorderDelay: Fixed(time.Second)},
+			wantOK:     true,
+			wantLater:  1,
+			advanceFor: time.Second,
+		},
+		{
+			partition: &Partition{Groups: [][]scp.NodeID{{"a"}, {"b"}}},
+			slotID:    1,
+		},
+		{
+			partition: &Partition{Groups: [][]scp.NodeID{{"a"}, {"c"}}},
+			slotID:    1,
+			wantOK:    true,
+			wantNow:   1,
+		},
+		{
+			partition: &Partition{Groups: [][]scp.NodeID{{"a"}, {"b"}}, FromSlot: 2},
+			slotID:    1,
+			wantOK:    true,
+			wantNow:   1,
+		},
+		{
+			partition: &Partition{Groups: [][]scp.NodeID{{"a"}, {"b"}}, Start: time.Second},
+			slotID:    1,
+			wantOK:    true,
+			wantNow:   1,
+		},
+	}
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%02d", i+1), func(t *testing.T) {
+			clock := &fakeClock{now: time.Unix(0, 0)}
+			inj := New(clock, 1)
+			var a, b recorder
+			inj.Add("a", &a)
+			inj.Add("b", &b)
+			inj.SetLink("a", "b", tc.link)
+			if tc.partition != nil {
+				inj.Schedule(*tc.partition)
+			}
+
+			msg := scp.NewMsg("a", tc.slotID, scp.QSet{}, &scp.NomTopic{})
+			ok := inj.Send("b", msg)
+			if ok != tc.wantOK {
+				t.Errorf("got ok=%v, want %v", ok, tc.wantOK)
+			}
+			if len(b) != tc.wantNow {
+				t.Errorf("got %d immediate deliveries, want %d", len(b), tc.wantNow)
+			}
+			clock.advance(tc.advanceFor)
+			if len(b) != tc.wantNow+tc.wantLater {
+				t.Errorf("got %d total deliveries, want %d", len(b), tc.wantNow+tc.wantLater)
+			}
+			if len(a) != 0 {
+				t.Errorf("got %d deliveries to the sender, want 0", len(a))
+			}
+		})
+	}
+}
+
+func TestPartitionHeals(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	inj := New(clock, 1)
+	var b recorder
+	inj.Add("b", &b)
+	inj.Schedule(Partition{
+		Groups: [][]scp.NodeID{{"a"}, {"b"}},
+		End:    time.Minute,
+	})
+
+	if inj.Send("b", scp.NewMsg("a", 1, scp.QSet{}, &scp.NomTopic{})) {
+		t.Error("message crossed partition")
+	}
+	clock.advance(time.Minute)
+	if !inj.Send("b", scp.NewMsg("a", 1, scp.QSet{}, &scp.NomTopic{})) {
+		t.Error("message blocked after partition healed")
+	}
+	if len(b) != 1 {
+		t.Errorf("got %d deliveries, want 1", len(b))
+	}
+}
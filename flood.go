@@ -0,0 +1,248 @@
+package scp
+
+import "sort"
+
+// SlotLimits bounds the resources a node devotes to slots it has not
+// externalized, so that a peer sending messages for far-future slots,
+// or for many distinct slots, cannot exhaust its memory and timers.
+//
+// A message for a slot more than Window past the node's highest
+// externalized slot (see HighestExt) is parked rather than handled.
+// So is a message that would create a new slot while MaxPending slots
+// are already pending. A node other than a watcher (see NewWatcher)
+// also parks messages for a slot whose predecessor it has not
+// externalized, since it cannot begin nominating until then. Such a
+// node ordinarily has only one pending slot, the one after its highest
+// externalized slot, so Window and MaxPending matter only for
+// watchers. Parked messages are handled, in slot order, once they come
+// within those limits.
+//
+// The node parks at most MaxParked messages, keeping only the latest
+// from each sender for each slot, and at most MaxParkedPerSender from
+// any one sender. When the buffer is full, messages from nodes outside
+// the quorum slices the node uses for their slots are dropped before
+// those from nodes within them, and messages for higher slots before
+// those for lower ones. So a flood of messages from strangers cannot
+// displace those from the node's own validators. ExportState includes
+// parked messages.
+//
+// A zero field means the corresponding default below.
+type SlotLimits struct {
+	Window             SlotID `json:"window,omitempty"`
+	MaxPending         int    `json:"max_pending,omitempty"`
+	MaxParked          int    `json:"max_parked,omitempty"`
+	MaxParkedPerSender int    `json:"max_parked_per_sender,omitempty"`
+}
+
+// Defaults for the fields of SlotLimits.
+const (
+	DefaultSlotWindow         = 16
+	DefaultMaxPending         = 16
+	DefaultMaxParked          = 4096
+	DefaultMaxParkedPerSender = 32
+)
+
+func (l SlotLimits) window() SlotID {
+	if l.Window > 0 {
+		return l.Window
+	}
+	return DefaultSlotWindow
+}
+
+func (l SlotLimits) maxPending() int {
+	if l.MaxPending > 0 {
+		return l.MaxPending
+	}
+	return DefaultMaxPending
+}
+
+func (l SlotLimits) maxParked() int {
+	if l.MaxParked > 0 {
+		return l.MaxParked
+	}
+	return DefaultMaxParked
+}
+
+func (l SlotLimits) maxParkedPerSender() int {
+	if l.MaxParkedPerSender > 0 {
+		return l.MaxParkedPerSender
+	}
+	return DefaultMaxParkedPerSender
+}
+
+// Tells whether the given slot is pending (or, for a watcher, being
+// watched).
+func (n *Node) isPending(i SlotID) bool {
+	if n.watched != nil {
+		_, ok := n.watched[i]
+		return ok
+	}
+	_, ok := n.pending[i]
+	return ok
+}
+
+func (n *Node) numPending() int {
+	if n.watched != nil {
+		return len(n.watched)
+	}
+	return len(n.pending)
+}
+
+// Tells whether a message for slot i is within the node's limits,
+// given that extra new slots will be created before it is handled.
+// The function highest tells the node's highest externalized slot;
+// it's called only if needed.
+func (n *Node) inRange(i SlotID, highest func() SlotID, extra int) bool {
+	if _, ok := n.ext[i]; ok || n.isPending(i) {
+		return true
+	}
+	if n.watched == nil && i > 1 {
+		// See Node.G.
+		if _, ok := n.ext[i-1]; !ok {
+			return false
+		}
+	}
+	if n.numPending()+extra >= n.Limits.maxPending() {
+		return false
+	}
+	return i <= highest()+n.Limits.window()
+}
+
+// Parks msg if it's outside the node's limits, and tells whether it
+// did.
+func (n *Node) park(msg *Msg) bool {
+	if n.inRange(msg.I, n.HighestExt, 0) {
+		return false
+	}
+
+	if n.parked == nil {
+		n.parked = make(map[SlotID]map[NodeID]*Msg)
+	}
+	msgs := n.parked[msg.I]
+	if have, ok := msgs[msg.V]; ok {
+		if have.T.Less(msg.T) {
+			msgs[msg.V] = msg
+		}
+		return true
+	}
+	if n.numParkedFrom(msg.V) >= n.Limits.maxParkedPerSender() {
+		n.Logf("too many parked messages from %s, dropping %s", msg.V, msg)
+		return true
+	}
+	if n.nparked >= n.Limits.maxParked() {
+		// Make room by dropping the parked message that ranks lowest,
+		// unless that would be msg itself.
+		victim := n.parkVictim()
+		if !n.parkOutranks(msg, victim) {
+			n.Logf("parking buffer full, dropping %s", msg)
+			return true
+		}
+		n.Logf("parking buffer full, dropping %s", victim)
+		delete(n.parked[victim.I], victim.V)
+		if len(n.parked[victim.I]) == 0 {
+			delete(n.parked, victim.I)
+		}
+		n.nparked--
+	}
+	if msgs == nil {
+		msgs = make(map[NodeID]*Msg)
+		n.parked[msg.I] = msgs
+	}
+	msgs[msg.V] = msg
+	n.nparked++
+	return true
+}
+
+// Counts the parked messages from the given node.
+func (n *Node) numParkedFrom(nodeID NodeID) int {
+	var count int
+	for _, msgs := range n.parked {
+		if _, ok := msgs[nodeID]; ok {
+			count++
+		}
+	}
+	return count
+}
+
+// Tells whether the node would rather keep parked message a than b:
+// a message from a node in the slot's quorum slices outranks one from
+// a stranger, and otherwise a message for a lower slot outranks one
+// for a higher slot.
+func (n *Node) parkOutranks(a, b *Msg) bool {
+	aMember, bMember := n.isSliceMember(a), n.isSliceMember(b)
+	if aMember != bMember {
+		return aMember
+	}
+	return a.I < b.I
+}
+
+func (n *Node) isSliceMember(msg *Msg) bool {
+	return n.qset(msg.I).Nodes().Contains(msg.V)
+}
+
+// Chooses the parked message to drop when the buffer is full: from
+// the highest slot holding a message from a stranger, if there is
+// one, else from the highest slot. Within a slot it chooses the
+// highest sender, for determinism. The buffer must not be empty.
+func (n *Node) parkVictim() *Msg {
+	var slotIDs []SlotID
+	for slotID := range n.parked {
+		slotIDs = append(slotIDs, slotID)
+	}
+	sort.Slice(slotIDs, func(i, j int) bool { return slotIDs[i] > slotIDs[j] })
+
+	// Picks the highest sender in msgs passing f.
+	pick := func(msgs map[NodeID]*Msg, f func(*Msg) bool) *Msg {
+		var result *Msg
+		for _, msg := range msgs {
+			if f(msg) && (result == nil || result.V.Less(msg.V)) {
+				result = msg
+			}
+		}
+		return result
+	}
+	for _, slotID := range slotIDs {
+		members := n.qset(slotID).Nodes()
+		if msg := pick(n.parked[slotID], func(msg *Msg) bool { return !members.Contains(msg.V) }); msg != nil {
+			return msg
+		}
+	}
+	return pick(n.parked[slotIDs[0]], func(*Msg) bool { return true })
+}
+
+// Requeues the parked messages that are now within the node's
+// limits, in order of slot and then sender.
+func (n *Node) unpark() {
+	if n.nparked == 0 {
+		return
+	}
+	var slotIDs []SlotID
+	for slotID := range n.parked {
+		slotIDs = append(slotIDs, slotID)
+	}
+	sort.Slice(slotIDs, func(i, j int) bool { return slotIDs[i] < slotIDs[j] })
+
+	var (
+		highest = n.HighestExt()
+		extra   int
+	)
+	for _, slotID := range slotIDs {
+		if !n.inRange(slotID, func() SlotID { return highest }, extra) {
+			continue
+		}
+		if _, ok := n.ext[slotID]; !ok && !n.isPending(slotID) {
+			extra++
+		}
+		msgs := n.parked[slotID]
+		var senders NodeIDSet
+		for nodeID := range msgs {
+			senders = append(senders, nodeID)
+		}
+		sort.Slice(senders, func(i, j int) bool { return senders[i].Less(senders[j]) })
+		for _, nodeID := range senders {
+			n.cmds.write(&msgCmd{msg: msgs[nodeID]})
+		}
+		n.nparked -= len(msgs)
+		delete(n.parked, slotID)
+	}
+}
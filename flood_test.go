@@ -0,0 +1,223 @@
+package scp
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestSlotLimits(t *testing.T) {
+	ch := make(chan *Msg, 10)
+	q := slicesToQSet([]NodeIDSet{{"b"}, {"c"}})
+	ext := map[SlotID]*ExtTopic{1: {C: Ballot{N: 1, X: valtype(1)}, HN: 1}}
+	n := NewNode("a", q, ch, ext)
+	n.Limits = SlotLimits{Window: 3, MaxPending: 1, MaxParked: 3}
+
+	nom := func(from NodeID, i SlotID) *Msg {
+		return NewMsg(from, i, q, &NomTopic{X: ValueSet{valtype(i)}})
+	}
+	handle := func(msg *Msg) {
+		t.Helper()
+		if err := n.handle(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	parked := func() map[SlotID]int {
+		res := make(map[SlotID]int)
+		for slotID, msgs := range n.parked {
+			res[slotID] = len(msgs)
+		}
+		return res
+	}
+
+	handle(nom("b", 2))
+	if _, ok := n.pending[2]; !ok {
+		t.Fatal("slot 2 not pending")
+	}
+
+	// Slot 3 is within the window, but slot 2 is not externalized
+	// (and there are already MaxPending slots).
+	handle(nom("b", 3))
+	// Slot 5 is past the window.
+	handle(nom("c", 5))
+	handle(nom("b", 5))
+	if len(n.pending) != 1 {
+		t.Errorf("%d pending slots, want 1", len(n.pending))
+	}
+	if got, want := parked(), map[SlotID]int{3: 1, 5: 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("parked %v, want %v", got, want)
+	}
+
+	// With the buffer full, a message for a higher slot is dropped...
+	handle(nom("b", 1000000000))
+	if got, want := parked(), map[SlotID]int{3: 1, 5: 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("parked %v, want %v", got, want)
+	}
+	// ...and one for a lower slot displaces one for the highest.
+	handle(nom("c", 4))
+	if got, want := parked(), map[SlotID]int{3: 1, 4: 1, 5: 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("parked %v, want %v", got, want)
+	}
+	// A newer message from the same sender for a parked slot replaces
+	// the older one.
+	newer := NewMsg("b", 3, q, &NomTopic{X: ValueSet{valtype(3), valtype(4)}})
+	handle(newer)
+	if n.parked[3]["b"] != newer || n.nparked != 3 {
+		t.Errorf("parked %v (%d), want newer message for slot 3", n.parked, n.nparked)
+	}
+
+	// Once slot 2 is externalized, there is room for slot 3 but not
+	// for the slots after it.
+	n.ext[2] = &ExtTopic{C: Ballot{N: 1, X: valtype(2)}, HN: 1}
+	delete(n.pending, 2)
+	n.unpark()
+	if got, want := parked(), map[SlotID]int{4: 1, 5: 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("parked %v, want %v", got, want)
+	}
+	cmd, ok := n.cmds.poll()
+	if !ok {
+		t.Fatal("no message requeued")
+	}
+	if got := cmd.(*msgCmd).msg; got != newer {
+		t.Errorf("requeued %s, want %s", got, newer)
+	}
+	if _, ok := n.cmds.poll(); ok {
+		t.Error("more than one message requeued")
+	}
+}
+
+func TestParkUntilPredecessor(t *testing.T) {
+	ch := make(chan *Msg, 10)
+	q := slicesToQSet([]NodeIDSet{{"b"}, {"c"}})
+	ext := map[SlotID]*ExtTopic{1: {C: Ballot{N: 1, X: valtype(1)}, HN: 1}}
+	n := NewNode("a", q, ch, ext)
+
+	msg3 := NewMsg("b", 3, q, &NomTopic{X: ValueSet{valtype(3)}})
+	if err := n.handle(msg3); err != nil {
+		t.Fatal(err)
+	}
+	if len(n.pending) != 0 || n.nparked != 1 {
+		t.Fatalf("%d pending, %d parked; want 0 pending, 1 parked", len(n.pending), n.nparked)
+	}
+
+	if err := n.handle(NewMsg("b", 2, q, &NomTopic{X: ValueSet{valtype(2)}})); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := n.pending[2]; !ok {
+		t.Fatal("slot 2 not pending")
+	}
+	n.unpark()
+	if n.nparked != 1 {
+		t.Errorf("%d parked before slot 2 is externalized, want 1", n.nparked)
+	}
+
+	n.ext[2] = &ExtTopic{C: Ballot{N: 1, X: valtype(2)}, HN: 1}
+	delete(n.pending, 2)
+	n.unpark()
+	cmd, ok := n.cmds.poll()
+	if !ok || cmd.(*msgCmd).msg != msg3 {
+		t.Fatalf("requeued %v, want %s", cmd, msg3)
+	}
+	if err := n.handle(msg3); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := n.pending[3]; !ok {
+		t.Error("slot 3 not pending")
+	}
+}
+
+func TestWatcherSlotLimits(t *testing.T) {
+	ext := map[SlotID]*ExtTopic{1: {C: Ballot{N: 1, X: valtype(1)}, HN: 1}}
+	w := NewWatcher("w", ext)
+	w.Limits = SlotLimits{Window: 3, MaxPending: 2}
+
+	nom := func(from NodeID, i SlotID) *Msg {
+		var others []NodeIDSet
+		for _, id := range []NodeID{"a", "b", "c"} {
+			if id != from {
+				others = append(others, NodeIDSet{id})
+			}
+		}
+		return NewMsg(from, i, slicesToQSet(others), &NomTopic{X: ValueSet{valtype(i)}})
+	}
+	msg4 := nom("b", 4)
+	for _, msg := range []*Msg{nom("b", 2), nom("b", 3), msg4, nom("c", 5)} {
+		if err := w.handle(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A watcher needs no predecessor, so it watches two slots at once.
+	// Slot 4 waits for room, and slot 5 is past the window.
+	if len(w.watched) != 2 || w.watched[2] == nil || w.watched[3] == nil {
+		t.Errorf("watching %v, want slots 2 and 3", w.watched)
+	}
+	if w.nparked != 2 || len(w.parked[4]) != 1 || len(w.parked[5]) != 1 {
+		t.Errorf("parked %v, want slots 4 and 5", w.parked)
+	}
+
+	// Once slot 2 is done, slot 4 fits but slot 5 would exceed
+	// MaxPending.
+	w.ext[2] = &ExtTopic{C: Ballot{N: 1, X: valtype(2)}, HN: 1}
+	delete(w.watched, 2)
+	w.unpark()
+	cmd, ok := w.cmds.poll()
+	if !ok || cmd.(*msgCmd).msg != msg4 {
+		t.Fatalf("requeued %v, want %s", cmd, msg4)
+	}
+	if _, ok := w.cmds.poll(); ok {
+		t.Error("more than one message requeued")
+	}
+	if w.nparked != 1 || len(w.parked[5]) != 1 {
+		t.Errorf("parked %v, want slot 5", w.parked)
+	}
+}
+
+func TestParkFlood(t *testing.T) {
+	q := slicesToQSet([]NodeIDSet{{"b"}, {"c"}})
+	ext := map[SlotID]*ExtTopic{1: {C: Ballot{N: 1, X: valtype(1)}, HN: 1}}
+	n := NewNode("a", q, nil, ext)
+	n.Limits = SlotLimits{MaxParked: 4, MaxParkedPerSender: 2}
+
+	nom := func(from NodeID, i SlotID) *Msg {
+		return NewMsg(from, i, q, &NomTopic{X: ValueSet{valtype(i)}})
+	}
+	handle := func(msg *Msg) {
+		t.Helper()
+		if err := n.handle(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Slot 2 is never externalized, so everything for later slots is
+	// parked.
+	handle(nom("b", 3))
+	handle(nom("b", 4))
+
+	// A stranger can park only MaxParkedPerSender messages...
+	for i := SlotID(3); i < 100; i++ {
+		handle(nom("x", i))
+	}
+	if got := n.numParkedFrom("x"); got != 2 {
+		t.Errorf("%d messages parked from x, want 2", got)
+	}
+
+	// ...and many strangers together cannot displace the validators.
+	for i := 0; i < 100; i++ {
+		handle(nom(NodeID(fmt.Sprintf("f%d", i)), 3))
+	}
+	// A validator can displace a stranger, even for a higher slot.
+	handle(nom("c", 5))
+
+	if n.nparked != 4 {
+		t.Errorf("%d parked messages, want 4", n.nparked)
+	}
+	for _, want := range []struct {
+		from NodeID
+		slot SlotID
+	}{{"b", 3}, {"b", 4}, {"c", 5}} {
+		if n.parked[want.slot][want.from] == nil {
+			t.Errorf("message from %s for slot %d not parked (have %v)", want.from, want.slot, n.parked)
+		}
+	}
+}
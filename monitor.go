@@ -0,0 +1,249 @@
+package scp
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Monitor observes the messages sent by a set of nodes and checks
+// SCP's safety and liveness invariants as they go by:
+//
+//   - every message is well formed (see Msg.valid), e.g. CN <= HN <= B.N
+//     and PP < P with a different value;
+//   - no node's ballot counter decreases within a slot;
+//   - no node accepts commit for a ballot it previously aborted (by
+//     accepting as prepared a higher ballot with a different value);
+//   - no two nodes externalize different values for the same slot;
+//   - (via CheckLiveness) no node takes too long to externalize a slot.
+//
+// A Monitor can be fed from any source of messages, e.g. by calling
+// Observe on everything a simulation's nodes send. It is safe for
+// concurrent use.
+type Monitor struct {
+	// Faulty lists nodes that are not expected to behave correctly.
+	// Their messages are recorded in the history but not checked.
+	Faulty NodeIDSet
+
+	// HistoryLen is the number of most recent messages per slot
+	// included with each Violation. Zero means 100.
+	HistoryLen int
+
+	// StallAfter is the time a node may spend on a slot, from its
+	// first message about the slot, before CheckLiveness reports it.
+	// Zero means one minute.
+	StallAfter time.Duration
+
+	// Clock tells the time for liveness checking. Nil means RealClock.
+	Clock Clock
+
+	// OnViolation, if non-nil, is called for each violation as it is
+	// detected. It must not call methods of the Monitor.
+	OnViolation func(*Violation)
+
+	mu         sync.Mutex
+	slots      map[SlotID]*monSlot
+	violations []*Violation
+}
+
+// Violation describes a failed invariant.
+type Violation struct {
+	Node NodeID
+	Slot SlotID
+	Desc string
+
+	// History holds the most recent messages observed for the slot,
+	// ending with the one that revealed the violation (if any).
+	History []*Msg
+}
+
+func (v *Violation) Error() string {
+	return fmt.Sprintf("node %s, slot %d: %s", v.Node, v.Slot, v.Desc)
+}
+
+type monSlot struct {
+	history []*Msg
+	nodes   map[NodeID]*monNode
+	ext     map[NodeID]Value
+	agreeOK bool // false once a disagreement has been reported
+}
+
+type monNode struct {
+	first   time.Time
+	bn      int
+	ap      BallotSet // ballots accepted as prepared
+	ext     bool
+	stalled bool // true once a stall has been reported
+}
+
+// Observe checks a message sent by one of the monitored nodes.
+func (m *Monitor) Observe(msg *Msg) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.slots == nil {
+		m.slots = make(map[SlotID]*monSlot)
+	}
+	ms, ok := m.slots[msg.I]
+	if !ok {
+		ms = &monSlot{
+			nodes:   make(map[NodeID]*monNode),
+			ext:     make(map[NodeID]Value),
+			agreeOK: true,
+		}
+		m.slots[msg.I] = ms
+	}
+	histLen := m.HistoryLen
+	if histLen <= 0 {
+		histLen = 100
+	}
+	ms.history = append(ms.history, msg)
+	if len(ms.history) > histLen {
+		ms.history = ms.history[len(ms.history)-histLen:]
+	}
+
+	if m.Faulty.Contains(msg.V) {
+		return
+	}
+
+	mn, ok := ms.nodes[msg.V]
+	if !ok {
+		mn = &monNode{first: m.now()}
+		ms.nodes[msg.V] = mn
+	}
+
+	if err := msg.valid(); err != nil {
+		m.report(msg.V, msg.I, ms, err.Error())
+	}
+
+	// Ballot counter must not decrease.
+	if _, ok := msg.T.(*ExtTopic); !ok {
+		if bn := msg.bN(); bn > 0 {
+			if bn < mn.bn {
+				m.report(msg.V, msg.I, ms, fmt.Sprintf("ballot counter decreased from %d to %d", mn.bn, bn))
+			}
+			mn.bn = bn
+		}
+	}
+
+	// Must not accept commit for an aborted ballot.
+	if x, cn, ok := acceptedCommit(msg); ok {
+		for _, p := range mn.ap {
+			if ValueEqual(p.X, x) {
+				continue
+			}
+			if cn < p.N || (cn == p.N && x.Less(p.X)) {
+				m.report(msg.V, msg.I, ms, fmt.Sprintf("accepts commit <%d,%s> after aborting it by accepting %s as prepared", cn, VString(x), p))
+				break
+			}
+		}
+	}
+	if _, ok := msg.T.(*ExtTopic); !ok {
+		mn.ap = mn.ap.Union(msg.acceptsPreparedSet())
+	}
+
+	// Nodes must agree on externalized values.
+	if topic, ok := msg.T.(*ExtTopic); ok {
+		mn.ext = true
+		if _, ok := ms.ext[msg.V]; !ok {
+			ms.ext[msg.V] = topic.C.X
+		}
+		if ms.agreeOK {
+			for other, v := range ms.ext {
+				if !ValueEqual(v, topic.C.X) {
+					ms.agreeOK = false
+					m.report(msg.V, msg.I, ms, fmt.Sprintf("externalized %s but node %s externalized %s", VString(topic.C.X), other, VString(v)))
+					break
+				}
+			}
+		}
+	}
+}
+
+// Tells the value and lowest counter of the ballots for which msg
+// accepts commit, if any.
+func acceptedCommit(msg *Msg) (Value, int, bool) {
+	switch topic := msg.T.(type) {
+	case *CommitTopic:
+		return topic.B.X, topic.CN, true
+	case *ExtTopic:
+		return topic.C.X, topic.C.N, true
+	}
+	return nil, 0, false
+}
+
+// CheckLiveness reports, once each, any node that has been working on
+// a slot for longer than StallAfter without externalizing it.
+func (m *Monitor) CheckLiveness() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stallAfter := m.StallAfter
+	if stallAfter <= 0 {
+		stallAfter = time.Minute
+	}
+	now := m.now()
+
+	// Visit slots and nodes in order, for deterministic reporting.
+	var slotIDs []SlotID
+	for slotID := range m.slots {
+		slotIDs = append(slotIDs, slotID)
+	}
+	sort.Slice(slotIDs, func(i, j int) bool { return slotIDs[i] < slotIDs[j] })
+	for _, slotID := range slotIDs {
+		ms := m.slots[slotID]
+		var nodeIDs NodeIDSet
+		for nodeID := range ms.nodes {
+			nodeIDs = nodeIDs.Add(nodeID)
+		}
+		for _, nodeID := range nodeIDs {
+			mn := ms.nodes[nodeID]
+			if mn.ext || mn.stalled {
+				continue
+			}
+			if elapsed := now.Sub(mn.first); elapsed > stallAfter {
+				mn.stalled = true
+				m.report(nodeID, slotID, ms, fmt.Sprintf("has not externalized after %s", elapsed))
+			}
+		}
+	}
+}
+
+// Forget discards state for slots below the given one.
+func (m *Monitor) Forget(before SlotID) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for slotID := range m.slots {
+		if slotID < before {
+			delete(m.slots, slotID)
+		}
+	}
+}
+
+// Violations returns all violations detected so far.
+func (m *Monitor) Violations() []*Violation {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*Violation(nil), m.violations...)
+}
+
+func (m *Monitor) report(nodeID NodeID, slotID SlotID, ms *monSlot, desc string) {
+	v := &Violation{
+		Node:    nodeID,
+		Slot:    slotID,
+		Desc:    desc,
+		History: append([]*Msg(nil), ms.history...),
+	}
+	m.violations = append(m.violations, v)
+	if m.OnViolation != nil {
+		m.OnViolation(v)
+	}
+}
+
+func (m *Monitor) now() time.Time {
+	if m.Clock == nil {
+		return RealClock.Now()
+	}
+	return m.Clock.Now()
+}
@@ -0,0 +1,108 @@
+package scp
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMonitor(t *testing.T) {
+	cases := []struct {
+		msgs   []*Msg
+		faulty NodeIDSet
+		want   []string // substrings of the expected violations, in order
+	}{
+		{
+			msgs: []*Msg{
+				{V: "a", I: 1, T: &PrepTopic{B: Ballot{1, valtype(1)}}},
+				{V: "a", I: 1, T: &PrepTopic{B: Ballot{2, valtype(1)}, P: Ballot{1, valtype(1)}, HN: 1}},
+				{V: "a", I: 1, T: &CommitTopic{B: Ballot{2, valtype(1)}, PN: 2, CN: 1, HN: 2}},
+				{V: "a", I: 1, T: &ExtTopic{C: Ballot{1, valtype(1)}, HN: 2}},
+				{V: "b", I: 1, T: &ExtTopic{C: Ballot{1, valtype(1)}, HN: 2}},
+			},
+		},
+		{
+			msgs: []*Msg{
+				{V: "a", I: 1, T: &PrepTopic{B: Ballot{1, valtype(1)}, HN: 2}},
+			},
+			want: []string{"HN > BN"},
+		},
+		{
+			msgs: []*Msg{
+				{V: "a", I: 1, T: &PrepTopic{B: Ballot{3, valtype(1)}, P: Ballot{2, valtype(1)}, PP: Ballot{1, valtype(1)}}},
+			},
+			want: []string{"same value"},
+		},
+		{
+			msgs: []*Msg{
+				{V: "a", I: 1, T: &PrepTopic{B: Ballot{3, valtype(1)}}},
+				{V: "a", I: 1, T: &PrepTopic{B: Ballot{2, valtype(1)}}},
+			},
+			want: []string{"ballot counter decreased from 3 to 2"},
+		},
+		{
+			msgs: []*Msg{
+				{V: "a", I: 1, T: &PrepTopic{B: Ballot{3, valtype(2)}, P: Ballot{3, valtype(2)}}},
+				{V: "a", I: 1, T: &CommitTopic{B: Ballot{3, valtype(1)}, PN: 3, CN: 2, HN: 3}},
+			},
+			want: []string{"after aborting it"},
+		},
+		{
+			msgs: []*Msg{
+				{V: "a", I: 1, T: &ExtTopic{C: Ballot{1, valtype(1)}, HN: 1}},
+				{V: "b", I: 1, T: &ExtTopic{C: Ballot{1, valtype(2)}, HN: 1}},
+				{V: "c", I: 1, T: &ExtTopic{C: Ballot{1, valtype(3)}, HN: 1}},
+			},
+			want: []string{"externalized 2 but node a externalized 1"},
+		},
+		{
+			msgs: []*Msg{
+				{V: "a", I: 1, T: &ExtTopic{C: Ballot{1, valtype(1)}, HN: 1}},
+				{V: "b", I: 1, T: &ExtTopic{C: Ballot{1, valtype(2)}, HN: 1}},
+			},
+			faulty: NodeIDSet{"b"},
+		},
+	}
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("%02d", i+1), func(t *testing.T) {
+			m := &Monitor{Faulty: tc.faulty}
+			for _, msg := range tc.msgs {
+				m.Observe(msg)
+			}
+			got := m.Violations()
+			if len(got) != len(tc.want) {
+				t.Fatalf("got %d violations %v, want %d", len(got), got, len(tc.want))
+			}
+			for j, v := range got {
+				if !strings.Contains(v.Desc, tc.want[j]) {
+					t.Errorf("violation %d is %q, want it to contain %q", j, v.Desc, tc.want[j])
+				}
+				if len(v.History) == 0 || v.History[len(v.History)-1].V != v.Node {
+					t.Errorf("violation %d history does not end with the offending message", j)
+				}
+			}
+		})
+	}
+}
+
+func TestMonitorLiveness(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	m := &Monitor{Clock: clock, StallAfter: time.Minute}
+	m.Observe(&Msg{V: "a", I: 1, T: &NomTopic{X: ValueSet{valtype(1)}}})
+	m.Observe(&Msg{V: "b", I: 1, T: &NomTopic{X: ValueSet{valtype(1)}}})
+	m.Observe(&Msg{V: "b", I: 1, T: &ExtTopic{C: Ballot{1, valtype(1)}, HN: 1}})
+
+	m.CheckLiveness()
+	if got := m.Violations(); len(got) != 0 {
+		t.Fatalf("got %v, want no violations", got)
+	}
+
+	clock.now = clock.now.Add(2 * time.Minute)
+	m.CheckLiveness()
+	m.CheckLiveness()
+	got := m.Violations()
+	if len(got) != 1 || got[0].Node != "a" {
+		t.Fatalf("got %v, want one violation for node a", got)
+	}
+}
@@ -48,8 +48,13 @@ func (e *Msg) valid() (err error) {
 			if topic.B.Less(topic.P) {
 				return errors.New("P > B")
 			}
-			if !topic.PP.IsZero() && !topic.PP.Less(topic.P) {
-				return errors.New("PP >= P")
+			if !topic.PP.IsZero() {
+				if !topic.PP.Less(topic.P) {
+					return errors.New("PP >= P")
+				}
+				if ValueEqual(topic.PP.X, topic.P.X) {
+					return errors.New("PP and P have the same value")
+				}
 			}
 		}
 		if topic.CN > topic.HN {
@@ -9,8 +9,8 @@ import (
 	"fmt"
 	"log"
 	"math/big"
-	"math/rand"
-	"time"
+	"sort"
+	"sync"
 
 	"github.com/davecgh/go-xdr/xdr"
 )
@@ -27,12 +27,26 @@ type Node struct {
 	// For compactness,
 	// this representation does not include the node itself,
 	// though the node is understood to be in every slice.
+	// It must not be modified once the node is running;
+	// use SetQSet to change it for later slots.
 	Q QSet
 
-	// FP/FQ is a rational giving the odds that a call to Handle will fail (drop the incoming message).
-	// Self messages (from the node to itself) are never dropped.
-	// FQ==0 is treated as 0/1.
-	FP, FQ int
+	// Clock is the node's source of time.
+	// NewNode sets it to RealClock.
+	// It may be replaced (e.g. by a simulator) before the node starts processing messages.
+	Clock Clock
+
+	// OnDisagreement, if non-nil, is called when a peer's EXTERNALIZE
+	// message conflicts with a value this node has externalized.
+	// Either the peer is faulty or consensus has failed. If it is nil,
+	// the node panics with the *Disagreement instead.
+	// It may be set before the node starts processing messages.
+	OnDisagreement func(*Disagreement)
+
+	// Limits bounds the slots the node will handle messages for at
+	// any one time (see SlotLimits).
+	// It may be set before the node starts processing messages.
+	Limits SlotLimits
 
 	// mu sync.Mutex
 
@@ -40,11 +54,30 @@ type Node struct {
 	pending map[SlotID]*Slot
 
 	// ext holds externalized values for slots that have completed
-	// balloting.
-	ext map[SlotID]*ExtTopic
-
-	cmds *cmdChan
-	send chan<- *Msg
+	// balloting. Other goroutines read it (e.g. with Externalized)
+	// while the node is running, so changes are made holding extMu.
+	ext   map[SlotID]*ExtTopic
+	extMu sync.RWMutex
+
+	// qsets holds changes made to Q with SetQSet, in increasing order
+	// of the slots from which they apply. Changes are made holding
+	// qsetsMu.
+	qsets   []qsetChange
+	qsetsMu sync.RWMutex
+
+	// watched holds the latest message from each peer for each pending
+	// slot, if this node is a watcher (see NewWatcher). It is nil
+	// otherwise.
+	watched map[SlotID]map[NodeID]*Msg
+
+	// parked holds messages for slots outside the node's Limits, and
+	// nparked counts them.
+	parked  map[SlotID]map[NodeID]*Msg
+	nparked int
+
+	cmds   *cmdChan
+	send   chan<- *Msg
+	tracer *tracer // non-nil if tracing, see Trace
 }
 
 // NewNode produces a new node.
@@ -55,6 +88,7 @@ func NewNode(id NodeID, q QSet, ch chan<- *Msg, ext map[SlotID]*ExtTopic) *Node
 	return &Node{
 		ID:      id,
 		Q:       q,
+		Clock:   RealClock,
 		pending: make(map[SlotID]*Slot),
 		ext:     ext,
 		cmds:    newCmdChan(),
@@ -65,7 +99,6 @@ func NewNode(id NodeID, q QSet, ch chan<- *Msg, ext map[SlotID]*ExtTopic) *Node
 // Run processes incoming events for the node. It returns only when
 // its context is canceled and should be launched as a goroutine.
 func (n *Node) Run(ctx context.Context) {
-	var delayUntil *time.Time
 	for {
 		cmd, ok := n.cmds.read(ctx)
 		if !ok {
@@ -76,49 +109,82 @@ func (n *Node) Run(ctx context.Context) {
 			}
 			return
 		}
-		switch cmd := cmd.(type) {
-		case *msgCmd:
-			func() {
-				if delayUntil != nil {
-					dur := time.Until(*delayUntil)
-					if dur > 0 {
-						time.Sleep(dur)
-					}
-					delayUntil = nil
-				}
-				err := n.handle(cmd.msg)
-				if err != nil {
-					n.Logf("ERROR %s", err)
-				}
-			}()
+		n.exec(cmd)
+	}
+}
+
+// Step processes the next queued event for the node, if there is
+// one, and tells whether it did. It never blocks. Step is an
+// alternative to Run for callers that drive a node synchronously,
+// such as a simulator, and must not be used together with Run.
+func (n *Node) Step() bool {
+	cmd, ok := n.cmds.poll()
+	if !ok {
+		return false
+	}
+	n.exec(cmd)
+	return true
+}
+
+func (n *Node) exec(cmd Cmd) {
+	if n.tracer != nil {
+		n.tracer.input(n, cmd)
+		defer n.tracer.checkPhases(n)
+	}
+
+	switch cmd := cmd.(type) {
+	case *msgCmd:
+		func() {
+			err := n.handle(cmd.msg)
+			if err != nil {
+				n.Logf("ERROR %s", err)
+			}
+		}()
 
-		case *delayCmd:
-			delayUntil = new(time.Time)
-			*delayUntil = time.Now().Add(time.Duration(cmd.ms * int(time.Millisecond)))
+	case *deferredUpdateCmd:
+		func() {
+			cmd.slot.deferredUpdate()
+		}()
 
-		case *deferredUpdateCmd:
-			func() {
-				cmd.slot.deferredUpdate()
-			}()
+	case *bumpBNCmd:
+		func() {
+			cmd.slot.bumpBN()
+		}()
 
-		case *newRoundCmd:
-			func() {
-				err := cmd.slot.newRound()
+	case *newRoundCmd:
+		func() {
+			err := cmd.slot.newRound()
+			if err != nil {
+				n.Logf("ERROR %s", err)
+			}
+		}()
+
+	case *setQSetCmd:
+		n.setQSet(cmd.q, cmd.from)
+
+	case *exportStateCmd:
+		state, err := n.exportState()
+		cmd.result <- exportResult{state: state, err: err}
+
+	case *importStateCmd:
+		n.importState(cmd.state)
+
+	case *rehandleCmd:
+		func() {
+			// Visit peers in a fixed order so that processing is
+			// deterministic.
+			var peerIDs NodeIDSet
+			for peerID := range cmd.slot.M {
+				peerIDs = append(peerIDs, peerID)
+			}
+			sort.Slice(peerIDs, func(i, j int) bool { return peerIDs[i].Less(peerIDs[j]) })
+			for _, peerID := range peerIDs {
+				err := n.handle(cmd.slot.M[peerID])
 				if err != nil {
 					n.Logf("ERROR %s", err)
 				}
-			}()
-
-		case *rehandleCmd:
-			func() {
-				for _, msg := range cmd.slot.M {
-					err := n.handle(msg)
-					if err != nil {
-						n.Logf("ERROR %s", err)
-					}
-				}
-			}()
-		}
+			}
+		}()
 	}
 }
 
@@ -130,6 +196,10 @@ func (n *Node) newRound(s *Slot) {
 	n.cmds.write(&newRoundCmd{slot: s})
 }
 
+func (n *Node) bumpBN(s *Slot) {
+	n.cmds.write(&bumpBNCmd{slot: s})
+}
+
 func (n *Node) rehandle(s *Slot) {
 	n.cmds.write(&rehandleCmd{slot: s})
 }
@@ -138,24 +208,24 @@ func (n *Node) rehandle(s *Slot) {
 // send a protocol message in response on n.send unless the incoming
 // message is ignored. (A message is ignored if it's invalid,
 // redundant, or older than another message already received from the
-// same sender.)
+// same sender.) A watcher (see NewWatcher) never responds. A message
+// for a slot outside the node's Limits is set aside until the slot
+// comes within them.
+//
+// To simulate an unreliable network, interpose a fault.Injector
+// between the transport and the node.
 func (n *Node) Handle(msg *Msg) {
-	if msg.V != n.ID && n.FQ > 0 && n.FP < n.FQ {
-		// decide whether to simulate dropping this message
-		if rand.Intn(n.FQ) < n.FP {
-			n.Logf("dropping message %s", msg)
-			return
-		}
-	}
 	n.cmds.write(&msgCmd{msg: msg})
 }
 
-// Delay simulates a network delay.
-func (n *Node) Delay(ms int) {
-	n.cmds.write(&delayCmd{ms: ms})
-}
-
 func (n *Node) handle(msg *Msg) error {
+	if n.park(msg) {
+		return nil
+	}
+	if n.watched != nil {
+		return n.watch(msg)
+	}
+
 	if topic, ok := n.ext[msg.I]; ok {
 		// This node has already externalized a value for the given slot.
 		// Send an EXTERNALIZE message outbound, unless the inbound
@@ -164,11 +234,10 @@ func (n *Node) handle(msg *Msg) error {
 			// Double check that the inbound EXTERNALIZE value agrees with
 			// this node.
 			if !ValueEqual(inTopic.C.X, topic.C.X) {
-				n.Logf("inbound message %s disagrees with externalized value %s!", msg, topic.C.X)
-				panic("consensus failure")
+				n.disagree(msg, topic.C.X)
 			}
 		} else {
-			n.send <- NewMsg(n.ID, msg.I, n.Q, topic)
+			n.emit(NewMsg(n.ID, msg.I, n.qset(msg.I), topic))
 		}
 		return nil
 	}
@@ -178,7 +247,11 @@ func (n *Node) handle(msg *Msg) error {
 		var err error
 		s, err = newSlot(msg.I, n)
 		if err != nil {
-			panic(fmt.Sprintf("cannot create slot %d: %s", msg.I, err))
+			// Messages for a slot whose predecessor this node has not
+			// externalized are parked (see SlotLimits), so this should not
+			// happen. If it does, the message is lost: peers do not repeat
+			// messages they have already sent.
+			return fmt.Errorf("cannot create slot %d: %s", msg.I, err)
 		}
 		n.pending[msg.I] = s
 	}
@@ -197,14 +270,46 @@ func (n *Node) handle(msg *Msg) error {
 		// Handling the inbound message resulted in externalizing a value.
 		// We can now save the EXTERNALIZE message and get rid of the Slot
 		// object.
+		n.extMu.Lock()
 		n.ext[msg.I] = extTopic
+		n.extMu.Unlock()
 		delete(n.pending, msg.I)
+		n.unpark()
 	}
 
-	n.send <- outbound
+	n.emit(outbound)
 	return nil
 }
 
+// A Disagreement is an EXTERNALIZE message from a peer that conflicts
+// with the value a node externalized for the same slot.
+type Disagreement struct {
+	Node NodeID // the node that externalized Val
+	Msg  *Msg
+	Val  Value
+}
+
+func (d *Disagreement) Error() string {
+	return fmt.Sprintf("consensus failure: node %s externalized %s, but got %s", d.Node, VString(d.Val), d.Msg)
+}
+
+// Reports a Disagreement (see OnDisagreement).
+func (n *Node) disagree(msg *Msg, val Value) {
+	d := &Disagreement{Node: n.ID, Msg: msg, Val: val}
+	if n.OnDisagreement == nil {
+		panic(d)
+	}
+	n.OnDisagreement(d)
+}
+
+// Sends an outbound protocol message.
+func (n *Node) emit(msg *Msg) {
+	if n.tracer != nil {
+		n.tracer.send(n, msg)
+	}
+	n.send <- msg
+}
+
 func (n *Node) ping() error {
 	for _, s := range n.pending {
 		for _, msg := range s.M {
@@ -247,29 +352,39 @@ func (n *Node) G(i SlotID, m []byte) (result [32]byte, err error) { // xxx unexp
 
 // Weight returns the fraction of n's quorum slices in which id
 // appears. Return value is the fraction and (as an optimization) a
-// bool indicating whether it's exactly 1.
+// bool indicating whether it's exactly 1. It uses the node's latest
+// quorum slices (see SetQSet).
 func (n *Node) Weight(id NodeID) (float64, bool) {
+	n.qsetsMu.RLock()
+	defer n.qsetsMu.RUnlock()
+	return n.weight(n.latestQSet(), id)
+}
+
+func (n *Node) weight(q QSet, id NodeID) (float64, bool) {
 	if id == n.ID {
 		return 1.0, true
 	}
-	return n.Q.weight(id)
+	return q.weight(id)
 }
 
 // Peers returns a flattened, uniquified list of the node IDs in n's
-// quorum slices, not including n's own ID.
+// latest quorum slices (see SetQSet), not including n's own ID.
 func (n *Node) Peers() NodeIDSet {
-	return n.Q.Nodes()
+	n.qsetsMu.RLock()
+	defer n.qsetsMu.RUnlock()
+	return n.latestQSet().Nodes()
 }
 
 // Neighbors produces a deterministic subset of a node's peers (which
 // may include itself) that is specific to a given slot and
 // nomination-round.
 func (n *Node) Neighbors(i SlotID, num int) (NodeIDSet, error) {
-	peers := n.Peers()
+	q := n.qset(i)
+	peers := q.Nodes()
 	peers = peers.Add(n.ID)
 	var result NodeIDSet
 	for _, nodeID := range peers {
-		weight64, is1 := n.Weight(nodeID)
+		weight64, is1 := n.weight(q, nodeID)
 		var hwBytes []byte
 		if is1 {
 			hwBytes = maxUint256[:]
@@ -326,6 +441,9 @@ func (n *Node) AllKnown() NodeIDSet {
 // HighestExt returns the ID of the highest slot for which this node
 // has an externalized value.
 func (n *Node) HighestExt() SlotID {
+	n.extMu.RLock()
+	defer n.extMu.RUnlock()
+
 	var result SlotID
 	for slotID := range n.ext {
 		if slotID > result {
@@ -335,11 +453,61 @@ func (n *Node) HighestExt() SlotID {
 	return result
 }
 
+// Externalized tells the value this node externalized for the given
+// slot, if any. It may be called while the node is running.
+func (n *Node) Externalized(i SlotID) (Value, bool) {
+	n.extMu.RLock()
+	defer n.extMu.RUnlock()
+
+	topic, ok := n.ext[i]
+	if !ok {
+		return nil, false
+	}
+	return topic.C.X, true
+}
+
+// ExtRange calls f for each slot from "from" through "to", inclusive,
+// for which this node has externalized a value, in increasing slot
+// order. It stops early if f returns false. It may be called while
+// the node is running, and f may call other methods of the node. Slots
+// externalized during the call may or may not be included.
+func (n *Node) ExtRange(from, to SlotID, f func(SlotID, Value) bool) {
+	type ext struct {
+		slotID SlotID
+		val    Value
+	}
+	var exts []ext
+
+	n.extMu.RLock()
+	if to >= from && int(to-from) < len(n.ext) {
+		for slotID := from; slotID <= to; slotID++ {
+			if topic, ok := n.ext[slotID]; ok {
+				exts = append(exts, ext{slotID: slotID, val: topic.C.X})
+			}
+		}
+	} else {
+		for slotID, topic := range n.ext {
+			if slotID >= from && slotID <= to {
+				exts = append(exts, ext{slotID: slotID, val: topic.C.X})
+			}
+		}
+		sort.Slice(exts, func(i, j int) bool { return exts[i].slotID < exts[j].slotID })
+	}
+	n.extMu.RUnlock()
+
+	for _, e := range exts {
+		if !f(e.slotID, e.val) {
+			return
+		}
+	}
+}
+
 // MsgsSince returns all this node's messages with slotID > since.
 // TODO: need a better interface, this list could get hella big.
 func (n *Node) MsgsSince(since SlotID) []*Msg {
 	var result []*Msg
 
+	n.extMu.RLock()
 	for slotID, topic := range n.ext {
 		if slotID <= since {
 			continue
@@ -347,11 +515,12 @@ func (n *Node) MsgsSince(since SlotID) []*Msg {
 		msg := &Msg{
 			V: n.ID,
 			I: slotID,
-			Q: n.Q,
+			Q: n.qset(slotID),
 			T: topic,
 		}
 		result = append(result, msg)
 	}
+	n.extMu.RUnlock()
 	for slotID, slot := range n.pending {
 		if slotID <= since {
 			continue
@@ -112,3 +112,73 @@ func slicesToQSet(slices []NodeIDSet) QSet {
 	}
 	return result
 }
+
+func TestExternalized(t *testing.T) {
+	ext := make(map[SlotID]*ExtTopic)
+	for _, i := range []SlotID{1, 2, 4, 7} {
+		ext[i] = &ExtTopic{C: Ballot{N: 1, X: valtype(10 * i)}, HN: 1}
+	}
+	n := NewNode("a", QSet{}, nil, ext)
+
+	if v, ok := n.Externalized(4); !ok || !ValueEqual(v, valtype(40)) {
+		t.Errorf("Externalized(4) = %v, %v, want 40, true", v, ok)
+	}
+	if v, ok := n.Externalized(3); ok {
+		t.Errorf("Externalized(3) = %v, want nothing", v)
+	}
+
+	cases := []struct {
+		from, to SlotID
+		stop     int
+		want     string
+	}{
+		{from: 1, to: 7, want: "1:10 2:20 4:40 7:70"},
+		{from: 2, to: 5, want: "2:20 4:40"},
+		{from: 0, to: 1000000, want: "1:10 2:20 4:40 7:70"},
+		{from: 3, to: 3},
+		{from: 5, to: 1},
+		{from: 1, to: 7, stop: 2, want: "1:10 2:20"},
+	}
+	for _, tc := range cases {
+		var got []string
+		n.ExtRange(tc.from, tc.to, func(i SlotID, v Value) bool {
+			got = append(got, fmt.Sprintf("%d:%s", i, VString(v)))
+			return len(got) != tc.stop
+		})
+		if strings.Join(got, " ") != tc.want {
+			t.Errorf("ExtRange(%d, %d) with stop %d produced %v, want %s", tc.from, tc.to, tc.stop, got, tc.want)
+		}
+	}
+}
+
+func TestDisagreement(t *testing.T) {
+	ch := make(chan *Msg, 10)
+	q := slicesToQSet([]NodeIDSet{{"b"}})
+	ext := map[SlotID]*ExtTopic{1: {C: Ballot{N: 1, X: valtype(1)}, HN: 1}}
+	n := NewNode("a", q, ch, ext)
+	msg := NewMsg("b", 1, q, &ExtTopic{C: Ballot{N: 1, X: valtype(2)}, HN: 1})
+
+	func() {
+		defer func() {
+			if _, ok := recover().(*Disagreement); !ok {
+				t.Error("no panic with a *Disagreement")
+			}
+		}()
+		n.handle(msg)
+	}()
+
+	var got *Disagreement
+	n.OnDisagreement = func(d *Disagreement) { got = d }
+	if err := n.handle(msg); err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.Msg != msg || !ValueEqual(got.Val, valtype(1)) {
+		t.Errorf("got disagreement %v, want one for %s", got, msg)
+	}
+
+	// An agreeing EXTERNALIZE is fine.
+	got = nil
+	if err := n.handle(NewMsg("b", 1, q, &ExtTopic{C: Ballot{N: 1, X: valtype(1)}, HN: 1})); err != nil || got != nil {
+		t.Errorf("agreeing message gave error %v, disagreement %v", err, got)
+	}
+}
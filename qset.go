@@ -32,70 +32,35 @@ type (
 //
 // Works by finding len(q.M)-q.T+1 members for which pred is true
 func (q QSet) findBlockingSet(msgs map[NodeID]*Msg, pred predicate) (NodeIDSet, predicate) {
-	return findBlockingSetHelper(len(q.M)-q.T+1, q.M, msgs, pred, nil)
+	return q.findBlockingSetIn(newNodeIndex(), msgs, pred)
 }
 
-func findBlockingSetHelper(needed int, members []QSetMember, msgs map[NodeID]*Msg, pred predicate, sofar NodeIDSet) (NodeIDSet, predicate) {
-	if needed == 0 {
-		return sofar, pred
-	}
-	if needed > len(members) {
+// Like findBlockingSet, but interning node IDs in x.
+func (q QSet) findBlockingSetIn(x *nodeIndex, msgs map[NodeID]*Msg, pred predicate) (NodeIDSet, predicate) {
+	qs := newQuorumSearch(x, msgs)
+	ok, pred := qs.blockingSet(len(q.M)-q.T+1, q.M, pred)
+	if !ok {
 		return nil, pred
 	}
-	m0 := members[0]
-	switch {
-	case m0.N != nil:
-		if msg, ok := msgs[*m0.N]; ok {
-			if nextPred := pred.test(msg); nextPred != nil {
-				return findBlockingSetHelper(needed-1, members[1:], msgs, nextPred, sofar.Add(*m0.N))
-			}
-		}
-
-	case m0.Q != nil:
-		sofar2, pred2 := findBlockingSetHelper(len(m0.Q.M)-m0.Q.T+1, m0.Q.M, msgs, pred, sofar)
-		if len(sofar2) > 0 {
-			return findBlockingSetHelper(needed-1, members[1:], msgs, pred2, sofar2)
-		}
-	}
-	return findBlockingSetHelper(needed, members[1:], msgs, pred, sofar)
+	return qs.result(), pred
 }
 
 // Finds a quorum in which every node satisfies the given
 // predicate. The slot's node itself is presumed to satisfy the
 // predicate.
 func (q QSet) findQuorum(nodeID NodeID, m map[NodeID]*Msg, pred predicate) (NodeIDSet, predicate) {
-	return findQuorumHelper(q.T, q.M, m, pred, NodeIDSet{nodeID})
+	return q.findQuorumIn(newNodeIndex(), nodeID, m, pred)
 }
 
-func findQuorumHelper(threshold int, members []QSetMember, msgs map[NodeID]*Msg, pred predicate, sofar NodeIDSet) (NodeIDSet, predicate) {
-	if threshold == 0 {
-		return sofar, pred
-	}
-	if threshold > len(members) {
+// Like findQuorum, but interning node IDs in x.
+func (q QSet) findQuorumIn(x *nodeIndex, nodeID NodeID, m map[NodeID]*Msg, pred predicate) (NodeIDSet, predicate) {
+	qs := newQuorumSearch(x, m)
+	qs.add(nodeID)
+	ok, pred := qs.quorum(q.T, q.M, pred)
+	if !ok {
 		return nil, pred
 	}
-	m0 := members[0]
-	switch {
-	case m0.N != nil:
-		if sofar.Contains(*m0.N) {
-			return findQuorumHelper(threshold-1, members[1:], msgs, pred, sofar)
-		}
-		if msg, ok := msgs[*m0.N]; ok {
-			if nextPred := pred.test(msg); nextPred != nil {
-				sofar2, pred2 := findQuorumHelper(msg.Q.T, msg.Q.M, msgs, nextPred, sofar.Add(*m0.N))
-				if len(sofar2) > 0 {
-					return findQuorumHelper(threshold-1, members[1:], msgs, pred2, sofar2)
-				}
-			}
-		}
-
-	case m0.Q != nil:
-		sofar2, pred2 := findQuorumHelper(m0.Q.T, m0.Q.M, msgs, pred, sofar)
-		if len(sofar2) > 0 {
-			return findQuorumHelper(threshold-1, members[1:], msgs, pred2, sofar2)
-		}
-	}
-	return findQuorumHelper(threshold, members[1:], msgs, pred, sofar)
+	return qs.result(), pred
 }
 
 // Function weight returns the fraction of q's quorum slices in which id appears.
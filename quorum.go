@@ -29,7 +29,7 @@ package scp
 // Checks that at least one node in each quorum slice satisfies pred
 // (excluding the slot's node).
 func (s *Slot) findBlockingSet(pred predicate) NodeIDSet {
-	res, _ := s.V.Q.findBlockingSet(s.M, pred)
+	res, _ := s.qset().findBlockingSetIn(s.nodeIndex(), s.M, pred)
 	return res
 }
 
@@ -37,10 +37,20 @@ func (s *Slot) findBlockingSet(pred predicate) NodeIDSet {
 // predicate. The slot's node itself is presumed to satisfy the
 // predicate.
 func (s *Slot) findQuorum(pred predicate) NodeIDSet {
-	res, _ := s.V.Q.findQuorum(s.V.ID, s.M, pred)
+	res, _ := s.qset().findQuorumIn(s.nodeIndex(), s.V.ID, s.M, pred)
 	return res
 }
 
+// Tells the slot's node index, creating it if necessary. The index
+// lives only as long as the slot, so IDs interned for one slot's
+// senders do not accumulate in the node.
+func (s *Slot) nodeIndex() *nodeIndex {
+	if s.index == nil {
+		s.index = newNodeIndex()
+	}
+	return s.index
+}
+
 // Tells whether a statement can be accepted, either because a
 // blocking set accepts, or because a quorum votes-or-accepts it. The
 // function f should produce an "accepts" predicate when its argument
@@ -0,0 +1,135 @@
+package scp
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// A qsetChange replaces a node's QSet for the slots from the given one
+// onward.
+type qsetChange struct {
+	from SlotID
+	q    QSet
+}
+
+// SetQSet changes the node's quorum slices, effective for slot "from"
+// and those after it. The change is validated immediately and applied
+// when the node next processes commands (with Run or Step), in order
+// with the messages it handles.
+//
+// Slots the node has already externalized by then keep their QSets.
+// Pending slots the change applies to start using it at once, and the
+// node sends a message for each of them (once it has something to say)
+// so that peers learn of it.
+// Messages the node sends for a slot carry the QSet in effect for that
+// slot.
+//
+// SetQSet may be called while the node is running.
+func (n *Node) SetQSet(q QSet, from SlotID) error {
+	if err := q.validate(n.ID); err != nil {
+		return err
+	}
+	if highest := n.HighestExt(); from <= highest {
+		return fmt.Errorf("slot %d is already externalized", highest)
+	}
+	n.cmds.write(&setQSetCmd{q: q, from: from})
+	return nil
+}
+
+// QSetFor tells the quorum slices the node uses for the given slot.
+// It may be called while the node is running.
+func (n *Node) QSetFor(i SlotID) QSet {
+	n.qsetsMu.RLock()
+	defer n.qsetsMu.RUnlock()
+	return n.qset(i)
+}
+
+// Tells the QSet in effect for slot i. This doesn't lock n.qsetsMu,
+// so outside the node's own goroutine use QSetFor.
+func (n *Node) qset(i SlotID) QSet {
+	for j := len(n.qsets) - 1; j >= 0; j-- {
+		if n.qsets[j].from <= i {
+			return n.qsets[j].q
+		}
+	}
+	return n.Q
+}
+
+// Tells the QSet in effect for the latest slots.
+func (n *Node) latestQSet() QSet {
+	if len(n.qsets) == 0 {
+		return n.Q
+	}
+	return n.qsets[len(n.qsets)-1].q
+}
+
+func (s *Slot) qset() QSet {
+	return s.V.qset(s.ID)
+}
+
+func (n *Node) setQSet(q QSet, from SlotID) {
+	if highest := n.HighestExt(); from <= highest {
+		n.Logf("slot %d externalized before QSet change took effect, applying from slot %d", highest, highest+1)
+		from = highest + 1
+	}
+
+	n.qsetsMu.Lock()
+	j := len(n.qsets)
+	for j > 0 && n.qsets[j-1].from >= from {
+		j--
+	}
+	n.qsets = append(n.qsets[:j], qsetChange{from: from, q: q})
+	n.qsetsMu.Unlock()
+
+	var slotIDs []SlotID
+	for slotID := range n.pending {
+		if slotID >= from {
+			slotIDs = append(slotIDs, slotID)
+		}
+	}
+	sort.Slice(slotIDs, func(i, j int) bool { return slotIDs[i] < slotIDs[j] })
+	for _, slotID := range slotIDs {
+		s := n.pending[slotID]
+		if msg := s.Msg(); msg != nil {
+			s.sent = msg
+			n.emit(msg)
+		}
+		n.rehandle(s)
+	}
+}
+
+// Checks that q is well formed: each threshold is between 1 and the
+// number of members, each member is a node or a nested QSet, and no
+// node appears twice or is self.
+func (q QSet) validate(self NodeID) error {
+	seen := make(map[NodeID]bool)
+	var check func(QSet) error
+	check = func(q QSet) error {
+		if q.T < 1 || q.T > len(q.M) {
+			return fmt.Errorf("threshold %d out of range for %d members", q.T, len(q.M))
+		}
+		for _, m := range q.M {
+			switch {
+			case m.N != nil && m.Q != nil:
+				return errors.New("member is both a node and a QSet")
+			case m.N != nil:
+				if *m.N == self {
+					return fmt.Errorf("QSet includes node %s itself", self)
+				}
+				if seen[*m.N] {
+					return fmt.Errorf("node %s appears more than once", *m.N)
+				}
+				seen[*m.N] = true
+			case m.Q != nil:
+				if err := check(*m.Q); err != nil {
+					return err
+				}
+			default:
+				return errors.New("empty member")
+			}
+		}
+		return nil
+	}
+	return check(q)
+}
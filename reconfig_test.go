@@ -0,0 +1,108 @@
+package scp
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestQSetValidate(t *testing.T) {
+	a, b, c := NodeID("a"), NodeID("b"), NodeID("c")
+	cases := []struct {
+		q    QSet
+		want bool
+	}{
+		{q: QSet{T: 1, M: []QSetMember{{N: &b}}}, want: true},
+		{q: QSet{T: 2, M: []QSetMember{{N: &b}, {Q: &QSet{T: 1, M: []QSetMember{{N: &c}}}}}}, want: true},
+		{q: QSet{}},
+		{q: QSet{T: 2, M: []QSetMember{{N: &b}}}},
+		{q: QSet{T: 1, M: []QSetMember{{N: &a}}}},
+		{q: QSet{T: 1, M: []QSetMember{{N: &b}, {Q: &QSet{T: 1, M: []QSetMember{{N: &b}}}}}}},
+		{q: QSet{T: 1, M: []QSetMember{{N: &b}, {Q: &QSet{}}}}},
+		{q: QSet{T: 1, M: []QSetMember{{}}}},
+	}
+	for i, tc := range cases {
+		err := tc.q.validate(a)
+		if (err == nil) != tc.want {
+			t.Errorf("case %d: got error %v, want valid %v", i+1, err, tc.want)
+		}
+	}
+}
+
+func TestSetQSet(t *testing.T) {
+	ch := make(chan *Msg, 10)
+	q1 := slicesToQSet([]NodeIDSet{{"b"}, {"c"}})
+	q2 := slicesToQSet([]NodeIDSet{{"b", "c"}, {"d"}})
+	ext := map[SlotID]*ExtTopic{1: {C: Ballot{N: 1, X: valtype(1)}, HN: 1}}
+	n := NewNode("a", q1, ch, ext)
+
+	if err := n.SetQSet(q2, 1); err == nil {
+		t.Error("changing the QSet of an externalized slot succeeded, want error")
+	}
+	if err := n.SetQSet(QSet{T: 3, M: q2.M}, 3); err == nil {
+		t.Error("setting an invalid QSet succeeded, want error")
+	}
+
+	// Slot 2 becomes pending before the change.
+	n.Handle(NewMsg("b", 2, q1, &NomTopic{X: ValueSet{valtype(2)}}))
+	if err := n.SetQSet(q2, 2); err != nil {
+		t.Fatal(err)
+	}
+	n.Handle(NewMsg("c", 2, q1, &NomTopic{X: ValueSet{valtype(3)}}))
+	n.Handle(NewMsg("b", 1, q1, &NomTopic{X: ValueSet{valtype(1)}}))
+	for n.Step() {
+	}
+
+	if got := n.QSetFor(1); !reflect.DeepEqual(got, q1) {
+		t.Errorf("QSetFor(1) = %v, want %v", got, q1)
+	}
+	for _, slotID := range []SlotID{2, 3, 100} {
+		if got := n.QSetFor(slotID); !reflect.DeepEqual(got, q2) {
+			t.Errorf("QSetFor(%d) = %v, want %v", slotID, got, q2)
+		}
+	}
+
+	// The node's last message for each slot reports the slot's QSet.
+	sent := make(map[SlotID]*Msg)
+	for len(ch) > 0 {
+		msg := <-ch
+		sent[msg.I] = msg
+	}
+	for slotID, want := range map[SlotID]QSet{1: q1, 2: q2} {
+		msg, ok := sent[slotID]
+		if !ok {
+			t.Errorf("no message sent for slot %d", slotID)
+			continue
+		}
+		if !reflect.DeepEqual(msg.Q, want) {
+			t.Errorf("message for slot %d has QSet %v, want %v", slotID, msg.Q, want)
+		}
+	}
+
+	// A later change supersedes this one from its own slot onward.
+	if err := n.SetQSet(q1, 5); err != nil {
+		t.Fatal(err)
+	}
+	for n.Step() {
+	}
+	for slotID, want := range map[SlotID]QSet{4: q2, 5: q1} {
+		if got := n.QSetFor(slotID); !reflect.DeepEqual(got, want) {
+			t.Errorf("QSetFor(%d) = %v, want %v", slotID, got, want)
+		}
+	}
+
+	// Changes survive exporting and importing the node's state.
+	state, err := n.exportState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n2 := NewNode("a", q1, ch, nil)
+	if err = n2.ImportState(state, decodeValtype); err != nil {
+		t.Fatal(err)
+	}
+	n2.Step()
+	for _, slotID := range []SlotID{1, 2, 4, 5} {
+		if got, want := n2.QSetFor(slotID), n.QSetFor(slotID); !reflect.DeepEqual(got, want) {
+			t.Errorf("after import, QSetFor(%d) = %v, want %v", slotID, got, want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package sim
+
+import (
+	"container/heap"
+	"time"
+
+	"github.com/bobg/scp"
+)
+
+// Epoch is the virtual time at which every simulation starts.
+var Epoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Clock is a virtual scp.Clock. Time advances only as the simulation
+// processes scheduled events.
+type Clock struct {
+	now    time.Time
+	seq    int64
+	events eventHeap
+}
+
+type event struct {
+	at       time.Time
+	seq      int64 // breaks ties between events scheduled for the same time, in scheduling order
+	f        func()
+	canceled bool
+}
+
+// NewClock produces a Clock, starting at Epoch, for use outside a
+// Sim. The caller advances it with Advance. Like a Sim, it must be
+// used from a single goroutine.
+func NewClock() *Clock {
+	return &Clock{now: Epoch}
+}
+
+// Now implements scp.Clock.
+func (c *Clock) Now() time.Time {
+	return c.now
+}
+
+// AfterFunc implements scp.Clock.
+func (c *Clock) AfterFunc(d time.Duration, f func()) scp.Timer {
+	if d < 0 {
+		d = 0
+	}
+	c.seq++
+	ev := &event{at: c.now.Add(d), seq: c.seq, f: f}
+	heap.Push(&c.events, ev)
+	return ev
+}
+
+// Stop implements scp.Timer.
+func (ev *event) Stop() bool {
+	if ev.canceled || ev.f == nil {
+		return false
+	}
+	ev.canceled = true
+	return true
+}
+
+// Advances the clock to the next pending event and runs it. Returns
+// false if there are no pending events.
+func (c *Clock) step() bool {
+	for len(c.events) > 0 {
+		ev := heap.Pop(&c.events).(*event)
+		if ev.canceled {
+			continue
+		}
+		c.now = ev.at
+		f := ev.f
+		ev.f = nil // marks the event as fired
+		f()
+		return true
+	}
+	return false
+}
+
+// Advance runs, in order, the events scheduled within d of the
+// current time, then moves the clock to d past the current time.
+func (c *Clock) Advance(d time.Duration) {
+	until := c.now.Add(d)
+	for {
+		at, ok := c.next()
+		if !ok || at.After(until) {
+			break
+		}
+		c.step()
+	}
+	c.now = until
+}
+
+// Tells the time of the next pending event, if any.
+func (c *Clock) next() (time.Time, bool) {
+	for len(c.events) > 0 {
+		if ev := c.events[0]; !ev.canceled {
+			return ev.at, true
+		}
+		heap.Pop(&c.events)
+	}
+	return time.Time{}, false
+}
+
+type eventHeap []*event
+
+func (h eventHeap) Len() int { return len(h) }
+
+func (h eventHeap) Less(i, j int) bool {
+	if h[i].at.Equal(h[j].at) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].at.Before(h[j].at)
+}
+
+func (h eventHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *eventHeap) Push(x interface{}) { *h = append(*h, x.(*event)) }
+
+func (h *eventHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return x
+}
@@ -0,0 +1,209 @@
+// Package sim runs a network of SCP nodes in a deterministic,
+// single-threaded discrete-event simulation.
+//
+// All nodes share a virtual Clock. Nothing sleeps: the simulation
+// jumps directly from one scheduled event (a message delivery or a
+// node's timer) to the next. Messages travel through a
+// fault.Injector whose random choices come from a seeded source, so a
+// given seed and sequence of calls always produces the same run.
+package sim
+
+import (
+	"time"
+
+	"github.com/bobg/scp"
+	"github.com/bobg/scp/fault"
+)
+
+// Size of the buffer for nodes' outbound messages. Each command a
+// node processes sends at most one message per peer, and the buffer
+// is drained after every command, so this bounds the network size.
+const sendBuf = 4096
+
+// Sim is a simulated network of SCP nodes.
+type Sim struct {
+	// Clock is the virtual time shared by all nodes.
+	Clock *Clock
+
+	// Net carries messages between nodes.
+	// Its default Link delivers each message after a latency
+	// uniformly distributed between 0 and 100ms.
+	// Callers may reconfigure it with other latencies and faults.
+	Net *fault.Injector
+
+	// OnSend, if non-nil, is called for every message sent by a node,
+	// before it is delivered to the other nodes.
+	OnSend func(*scp.Msg)
+
+	// Alter, if non-nil, is applied to every message for each
+	// recipient before delivery, e.g. to model a misbehaving sender.
+	// It returns the message to deliver in place of msg, or nil to
+	// deliver nothing. Recipients are visited in sorted order.
+	Alter func(msg *scp.Msg, to scp.NodeID) *scp.Msg
+
+	nodes map[scp.NodeID]*scp.Node
+	ids   scp.NodeIDSet
+	ch    chan *scp.Msg
+	ext   map[scp.NodeID]map[scp.SlotID]scp.Value
+}
+
+// New produces a new, empty simulation whose random choices are
+// determined by seed.
+func New(seed int64) *Sim {
+	clock := NewClock()
+	net := fault.New(clock, seed)
+	net.SetDefault(fault.Link{Latency: fault.Uniform{Max: 100 * time.Millisecond}})
+	return &Sim{
+		Clock: clock,
+		Net:   net,
+		nodes: make(map[scp.NodeID]*scp.Node),
+		ch:    make(chan *scp.Msg, sendBuf),
+		ext:   make(map[scp.NodeID]map[scp.SlotID]scp.Value),
+	}
+}
+
+// AddNode adds a node with the given ID and quorum slices to the
+// network.
+func (s *Sim) AddNode(id scp.NodeID, q scp.QSet) *scp.Node {
+	node := scp.NewNode(id, q, s.ch, nil)
+	node.Clock = s.Clock
+	s.nodes[id] = node
+	s.ids = s.ids.Add(id)
+	s.ext[id] = make(map[scp.SlotID]scp.Value)
+	s.Net.Add(id, node)
+	return node
+}
+
+// Node returns the node with the given ID, or nil.
+func (s *Sim) Node(id scp.NodeID) *scp.Node {
+	return s.nodes[id]
+}
+
+// Nodes returns the IDs of all nodes in the network.
+func (s *Sim) Nodes() scp.NodeIDSet {
+	return s.ids
+}
+
+// Nominate causes the given node to nominate v for the given slot at
+// the current virtual time, under the quorum slices it uses for that
+// slot then.
+func (s *Sim) Nominate(id scp.NodeID, slotID scp.SlotID, v scp.Value) {
+	node := s.nodes[id]
+	s.Clock.AfterFunc(0, func() {
+		node.Handle(scp.NewMsg(id, slotID, node.QSetFor(slotID), &scp.NomTopic{X: scp.ValueSet{v}}))
+	})
+}
+
+// Step advances virtual time to the next scheduled event, runs it,
+// and lets every node process the consequences. It returns false if
+// no events remain.
+func (s *Sim) Step() bool {
+	if !s.Clock.step() {
+		return false
+	}
+	s.settle()
+	return true
+}
+
+// Run processes events until done returns true, no events remain,
+// or virtual time would advance more than d beyond the current time.
+// A nil done function runs until one of the other conditions holds.
+// Run tells whether done returned true.
+func (s *Sim) Run(d time.Duration, done func() bool) bool {
+	deadline := s.Clock.Now().Add(d)
+	for done == nil || !done() {
+		at, ok := s.Clock.next()
+		if !ok || at.After(deadline) {
+			return false
+		}
+		s.Step()
+	}
+	return true
+}
+
+// RunSlot has each node in vals nominate its value for the given
+// slot, then runs until all nodes have externalized a value for that
+// slot or until timeout elapses in virtual time. It tells whether all
+// nodes externalized.
+func (s *Sim) RunSlot(slotID scp.SlotID, vals map[scp.NodeID]scp.Value, timeout time.Duration) bool {
+	for _, id := range s.ids {
+		if v, ok := vals[id]; ok {
+			s.Nominate(id, slotID, v)
+		}
+	}
+	return s.Run(timeout, func() bool {
+		return s.AllExternalized(slotID)
+	})
+}
+
+// Externalized tells the value, if any, that the given node has
+// externalized for the given slot.
+func (s *Sim) Externalized(id scp.NodeID, slotID scp.SlotID) (scp.Value, bool) {
+	v, ok := s.ext[id][slotID]
+	return v, ok
+}
+
+// AllExternalized tells whether every node has externalized a value
+// for the given slot.
+func (s *Sim) AllExternalized(slotID scp.SlotID) bool {
+	for _, id := range s.ids {
+		if _, ok := s.ext[id][slotID]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Lets nodes process their queued commands, delivering the resulting
+// messages, until all nodes are idle. Nodes are visited in a fixed
+// order to keep the simulation deterministic.
+func (s *Sim) settle() {
+	for {
+		var progress bool
+		for _, id := range s.ids {
+			node := s.nodes[id]
+			for node.Step() {
+				progress = true
+				s.drain()
+			}
+		}
+		if !progress {
+			return
+		}
+	}
+}
+
+func (s *Sim) drain() {
+	for {
+		select {
+		case msg := <-s.ch:
+			s.deliver(msg)
+
+		default:
+			return
+		}
+	}
+}
+
+func (s *Sim) deliver(msg *scp.Msg) {
+	if topic, ok := msg.T.(*scp.ExtTopic); ok {
+		if _, ok := s.ext[msg.V][msg.I]; !ok {
+			s.ext[msg.V][msg.I] = topic.C.X
+		}
+	}
+	if s.OnSend != nil {
+		s.OnSend(msg)
+	}
+	if s.Alter == nil {
+		s.Net.Broadcast(msg)
+		return
+	}
+	for _, id := range s.ids {
+		if id == msg.V {
+			continue
+		}
+		if m := s.Alter(msg, id); m != nil {
+			s.Net.Send(id, m)
+		}
+	}
+}
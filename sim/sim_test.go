@@ -0,0 +1,268 @@
+package sim
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bobg/scp"
+)
+
+type valtype uint32
+
+func (v valtype) IsNil() bool { return false }
+
+func (v valtype) Less(other scp.Value) bool {
+	return v < other.(valtype)
+}
+
+func (v valtype) Combine(other scp.Value, _ scp.SlotID) scp.Value {
+	if v > other.(valtype) {
+		return v
+	}
+	return other
+}
+
+func (v valtype) Bytes() []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], uint32(v))
+	return buf[:]
+}
+
+func (v valtype) String() string {
+	return strconv.Itoa(int(v))
+}
+
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+// Produces a network of n nodes,
+// each of which requires t others for a quorum.
+func newNetwork(seed int64, n, t int) *Sim {
+	s := New(seed)
+	var ids []scp.NodeID
+	for i := 0; i < n; i++ {
+		ids = append(ids, scp.NodeID(fmt.Sprintf("n%02d", i)))
+	}
+	for _, id := range ids {
+		q := scp.QSet{T: t}
+		for _, other := range ids {
+			if other == id {
+				continue
+			}
+			other := other
+			q.M = append(q.M, scp.QSetMember{N: &other})
+		}
+		s.AddNode(id, q)
+	}
+	return s
+}
+
+// Runs slots 1 through numSlots and returns each node's sequence of
+// externalized values, plus the final virtual time.
+func runNetwork(t testing.TB, s *Sim, seed int64, numSlots int) ([]string, time.Time) {
+	rng := rand.New(rand.NewSource(seed))
+	var result []string
+	for slotID := scp.SlotID(1); slotID <= scp.SlotID(numSlots); slotID++ {
+		vals := make(map[scp.NodeID]scp.Value)
+		for _, id := range s.Nodes() {
+			vals[id] = valtype(rng.Intn(100))
+		}
+		if !s.RunSlot(slotID, vals, time.Hour) {
+			t.Fatalf("slot %d did not externalize", slotID)
+		}
+		for _, id := range s.Nodes() {
+			v, _ := s.Externalized(id, slotID)
+			result = append(result, fmt.Sprintf("%s:%d:%s", id, slotID, v))
+		}
+	}
+	return result, s.Clock.Now()
+}
+
+func TestAgreement(t *testing.T) {
+	s := newNetwork(1, 4, 2)
+	mon := &scp.Monitor{Clock: s.Clock}
+	s.OnSend = mon.Observe
+	runNetwork(t, s, 1, 10)
+	for _, v := range mon.Violations() {
+		t.Error(v)
+	}
+	for slotID := scp.SlotID(1); slotID <= 10; slotID++ {
+		var want scp.Value
+		for _, id := range s.Nodes() {
+			got, ok := s.Externalized(id, slotID)
+			if !ok {
+				t.Fatalf("node %s did not externalize slot %d", id, slotID)
+			}
+			if want == nil {
+				want = got
+			} else if !scp.ValueEqual(got, want) {
+				t.Errorf("slot %d: node %s externalized %s, want %s", slotID, id, got, want)
+			}
+		}
+	}
+}
+
+func TestDeterminism(t *testing.T) {
+	for seed := int64(1); seed <= 3; seed++ {
+		t.Run(fmt.Sprintf("seed%d", seed), func(t *testing.T) {
+			var msgs1, msgs2 []string
+			s1 := newNetwork(seed, 5, 3)
+			s1.OnSend = func(msg *scp.Msg) { msgs1 = append(msgs1, fmt.Sprintf("%s %d %s", msg.V, msg.I, msg.T)) }
+			s2 := newNetwork(seed, 5, 3)
+			s2.OnSend = func(msg *scp.Msg) { msgs2 = append(msgs2, fmt.Sprintf("%s %d %s", msg.V, msg.I, msg.T)) }
+
+			ext1, t1 := runNetwork(t, s1, seed, 5)
+			ext2, t2 := runNetwork(t, s2, seed, 5)
+
+			if !t1.Equal(t2) {
+				t.Errorf("runs ended at different virtual times: %s vs. %s", t1, t2)
+			}
+			if fmt.Sprint(ext1) != fmt.Sprint(ext2) {
+				t.Errorf("runs externalized different values:\n%v\n%v", ext1, ext2)
+			}
+			if fmt.Sprint(msgs1) != fmt.Sprint(msgs2) {
+				t.Error("runs sent different messages")
+			}
+		})
+	}
+}
+
+func TestAlter(t *testing.T) {
+	s := newNetwork(1, 4, 2)
+	// Node n03 says nothing to n00 and n01, which must reach
+	// agreement with n02 alone.
+	var altered int
+	s.Alter = func(msg *scp.Msg, to scp.NodeID) *scp.Msg {
+		if msg.V == "n03" && (to == "n00" || to == "n01") {
+			altered++
+			return nil
+		}
+		return msg
+	}
+	heard := make(map[scp.NodeID]bool)
+	for _, id := range s.Nodes() {
+		id := id
+		s.Net.Add(id, handlerFunc(func(msg *scp.Msg) {
+			if msg.V == "n03" {
+				heard[id] = true
+			}
+			s.Node(id).Handle(msg)
+		}))
+	}
+	runNetwork(t, s, 1, 1)
+	if altered == 0 {
+		t.Error("Alter never called")
+	}
+	if heard["n00"] || heard["n01"] || !heard["n02"] {
+		t.Errorf("nodes hearing from n03: %v, want n02 only", heard)
+	}
+}
+
+func TestNominateUsesSlotQSet(t *testing.T) {
+	s := newNetwork(1, 4, 2)
+	node := s.Node("n00")
+	q := node.Q
+	q.T = 3
+	if err := node.SetQSet(q, 2); err != nil {
+		t.Fatal(err)
+	}
+	trace := new(bytes.Buffer)
+	node.Trace(trace)
+	s.Nominate("n00", 1, valtype(1))
+	s.Nominate("n00", 2, valtype(2))
+	s.Step()
+	s.Step()
+
+	// The nominations appear in the trace as inbound messages.
+	want := map[scp.SlotID]scp.QSet{1: node.Q, 2: q}
+	dec := json.NewDecoder(trace)
+	for {
+		var ev struct {
+			Kind string
+			Msg  struct {
+				I scp.SlotID
+				Q scp.QSet
+			}
+		}
+		if err := dec.Decode(&ev); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if ev.Kind != "msg" {
+			continue
+		}
+		if q, ok := want[ev.Msg.I]; !ok {
+			t.Errorf("unexpected message for slot %d", ev.Msg.I)
+		} else if !reflect.DeepEqual(ev.Msg.Q, q) {
+			t.Errorf("nomination for slot %d has QSet %v, want %v", ev.Msg.I, ev.Msg.Q, q)
+		}
+		delete(want, ev.Msg.I)
+	}
+	if len(want) > 0 {
+		t.Errorf("no nominations for %v", want)
+	}
+}
+
+type handlerFunc func(*scp.Msg)
+
+func (f handlerFunc) Handle(msg *scp.Msg) { f(msg) }
+
+func BenchmarkSlots(b *testing.B) {
+	var (
+		s      = newNetwork(1, 4, 2)
+		rng    = rand.New(rand.NewSource(1))
+		slotID scp.SlotID
+		stalls int
+	)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		slotID++
+		vals := make(map[scp.NodeID]scp.Value)
+		for _, id := range s.Nodes() {
+			vals[id] = valtype(rng.Intn(100))
+		}
+		if !s.RunSlot(slotID, vals, time.Hour) {
+			// A stalled network can't proceed to the next slot. Start over.
+			stalls++
+			s = newNetwork(int64(i), 4, 2)
+			slotID = 0
+		}
+	}
+	b.ReportMetric(float64(stalls), "stalls")
+}
+
+func TestClockAdvance(t *testing.T) {
+	c := NewClock()
+	var got []int
+	c.AfterFunc(2*time.Second, func() { got = append(got, 2) })
+	c.AfterFunc(time.Second, func() { got = append(got, 1) })
+	stopped := c.AfterFunc(time.Second, func() { got = append(got, -1) })
+	c.AfterFunc(3*time.Second, func() { got = append(got, 3) })
+	stopped.Stop()
+
+	c.Advance(2 * time.Second)
+	if fmt.Sprint(got) != "[1 2]" {
+		t.Errorf("got %v, want [1 2]", got)
+	}
+	if want := Epoch.Add(2 * time.Second); !c.Now().Equal(want) {
+		t.Errorf("now is %s, want %s", c.Now(), want)
+	}
+	c.Advance(time.Second)
+	if fmt.Sprint(got) != "[1 2 3]" {
+		t.Errorf("got %v, want [1 2 3]", got)
+	}
+}
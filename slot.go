@@ -26,13 +26,18 @@ type Slot struct {
 
 	maxPriPeers    NodeIDSet // set of peers that have ever had max priority
 	lastRound      int       // latest round at which maxPriPeers was updated
-	nextRoundTimer *time.Timer
+	nextRoundTimer Timer
 
 	B     Ballot
 	P, PP Ballot // two highest "accepted prepared" ballots with differing values
 	C, H  Ballot // lowest and highest confirmed-prepared or accepted-commit ballots (depending on phase)
 
-	Upd *time.Timer // timer for invoking a deferred update
+	Upd Timer // timer for invoking a deferred update
+
+	pendingBN int   // ballot counter deferred by the limit on B.N, or 0
+	bump      Timer // timer for applying pendingBN
+
+	index *nodeIndex // interns the senders in M for quorum searches, see nodeIndex
 }
 
 // Phase is the type of a slot's phase.
@@ -51,7 +56,7 @@ func newSlot(id SlotID, n *Node) (*Slot, error) {
 		ID: id,
 		V:  n,
 		Ph: PhNom,
-		T:  time.Now(),
+		T:  n.Clock.Now(),
 		M:  make(map[NodeID]*Msg),
 	}
 	peerID, err := s.findMaxPriPeer(1)
@@ -76,6 +81,13 @@ var (
 	// (1+N)*DeferredUpdateInterval, where N is the value of the slot's
 	// ballot counter (B.N).
 	DeferredUpdateInterval = time.Second
+
+	// MaxBallotCounter and BallotCounterInterval limit the growth of a
+	// slot's ballot counter. B.N may not exceed MaxBallotCounter plus
+	// the number of BallotCounterIntervals elapsed since the slot was
+	// created.
+	MaxBallotCounter      = 1000
+	BallotCounterInterval = time.Second
 )
 
 // This embodies most of the nomination and balloting protocols. It
@@ -254,6 +266,7 @@ func (s *Slot) doCommitPhase() {
 		s.C.N = cn
 		s.H.N = hn
 		s.cancelUpd()
+		s.cancelBump()
 	}
 }
 
@@ -293,7 +306,7 @@ func (s *Slot) updateAcceptsCommitBounds() bool {
 }
 
 func (s *Slot) Msg() *Msg {
-	msg := NewMsg(s.V.ID, s.ID, s.V.Q, nil)
+	msg := NewMsg(s.V.ID, s.ID, s.qset(), nil)
 	switch s.Ph {
 	case PhNom:
 		if len(s.X) == 0 && len(s.Y) == 0 {
@@ -360,7 +373,7 @@ func (s *Slot) maybeScheduleUpd() {
 	if len(nodeIDs) == 0 {
 		return
 	}
-	s.Upd = time.AfterFunc(time.Duration((1+s.B.N)*int(DeferredUpdateInterval)), func() {
+	s.Upd = s.V.Clock.AfterFunc(time.Duration((1+s.B.N)*int(DeferredUpdateInterval)), func() {
 		s.V.deferredUpdate(s)
 	})
 }
@@ -385,7 +398,7 @@ func (s *Slot) deferredUpdate() {
 
 	s.Logf("deferred update: %s", msg)
 
-	s.V.send <- msg
+	s.V.emit(msg)
 }
 
 func (s *Slot) cancelUpd() {
@@ -451,22 +464,17 @@ func (s *Slot) updateB() {
 	// increases `ballot.counter` to the maximum permissible value,
 	// or, if it is already at this maximum, waits up to one second
 	// before increasing the value.
-	maxBN := 1000 + int(time.Since(s.T)/time.Second)
+	maxBN := s.maxBN()
 	if setBN <= maxBN {
 		s.B.N = setBN
 	} else if s.B.N < maxBN {
 		s.Logf("limiting B.N to %d (from %d)", maxBN, setBN)
 		s.B.N = maxBN
 	} else {
-		setBN = maxBN + 1
-
-		// The time when it's ok to set s.B.N to setBN (i.e., after it's been running for setBN-1000 seconds)
-		oktime := s.T.Add(time.Duration(setBN-1000) * time.Second)
-		until := time.Until(oktime)
-
-		s.Logf("limiting B.N to %d after a %s sleep", setBN, until)
-		time.Sleep(until)
-		s.B.N = setBN
+		// Don't block the node's goroutine waiting for the limit to
+		// rise. Schedule the increase instead.
+		s.scheduleBump(s.B.N + 1)
+		return
 	}
 	if doSetBX {
 		s.setBX()
@@ -474,6 +482,74 @@ func (s *Slot) updateB() {
 	}
 }
 
+// Tells the highest ballot counter currently permitted for this slot.
+func (s *Slot) maxBN() int {
+	return MaxBallotCounter + int(s.elapsed()/BallotCounterInterval)
+}
+
+// Arranges for s.B.N to be raised to bn once the limit on the ballot
+// counter permits it.
+func (s *Slot) scheduleBump(bn int) {
+	if bn <= s.pendingBN {
+		// Don't bother if this or a later increase is already scheduled.
+		return
+	}
+	s.pendingBN = bn
+	if s.bump != nil {
+		return
+	}
+
+	// The time when it's ok to set s.B.N to bn (i.e., after it's been
+	// running for bn-MaxBallotCounter intervals).
+	oktime := s.T.Add(time.Duration(bn-MaxBallotCounter) * BallotCounterInterval)
+	until := oktime.Sub(s.V.Clock.Now())
+
+	s.Logf("limiting B.N to %d after %s", bn, until)
+	s.bump = s.V.Clock.AfterFunc(until, func() {
+		s.V.bumpBN(s)
+	})
+}
+
+func (s *Slot) bumpBN() {
+	if s.bump == nil {
+		return
+	}
+
+	s.bump = nil
+	bn := s.pendingBN
+	s.pendingBN = 0
+	if bn <= s.B.N {
+		return
+	}
+
+	s.cancelUpd()
+	s.B.N = bn
+	s.setBX()
+	s.maybeScheduleUpd()
+
+	if s.isPrepPhase() {
+		s.doPrepPhase()
+	}
+	if s.Ph == PhCommit {
+		s.doCommitPhase()
+	}
+
+	msg := s.Msg()
+
+	s.Logf("ballot counter increase: %s", msg)
+
+	s.V.emit(msg)
+}
+
+func (s *Slot) cancelBump() {
+	if s.bump == nil {
+		return
+	}
+	stopTimer(s.bump)
+	s.bump = nil
+	s.pendingBN = 0
+}
+
 func (s *Slot) setBX() {
 	if s.Ph >= PhCommit {
 		return
@@ -497,7 +573,18 @@ func (s *Slot) setBX() {
 // quadratic formula this tells us that after an elapsed time of T,
 // it's round 1 + ((sqrt(8T+25)-5) / 2)
 func (s *Slot) Round() int {
-	return round(time.Since(s.T))
+	return round(s.elapsed())
+}
+
+// Tells how long it's been since the slot was created.
+func (s *Slot) elapsed() time.Duration {
+	return s.V.Clock.Now().Sub(s.T)
+}
+
+// NomRound tells the nomination round in progress at the given time
+// since the start of a slot. See Slot.Round.
+func NomRound(elapsed time.Duration) int {
+	return round(elapsed)
 }
 
 func round(d time.Duration) int {
@@ -534,9 +621,9 @@ func (s *Slot) newRound() error {
 }
 
 func (s *Slot) scheduleRound() {
-	dur := time.Until(s.roundTime(s.lastRound + 1))
+	dur := s.roundTime(s.lastRound + 1).Sub(s.V.Clock.Now())
 	// s.Logf("scheduling round %d for %s from now", s.lastRound+1, dur)
-	s.nextRoundTimer = time.AfterFunc(dur, func() {
+	s.nextRoundTimer = s.V.Clock.AfterFunc(dur, func() {
 		s.V.newRound(s)
 	})
 }
@@ -628,7 +715,7 @@ func (s *Slot) updateP() {
 	s.PP = ZeroBallot
 
 	var apOut BallotSet
-	peers := s.V.Peers()
+	peers := s.qset().Nodes()
 	for _, peerID := range peers {
 		if msg, ok := s.M[peerID]; ok {
 			apIn = apIn.Union(msg.votesOrAcceptsPreparedSet())
@@ -682,18 +769,11 @@ func (s *Slot) Logf(f string, a ...interface{}) {
 	s.V.Logf(f, a...)
 }
 
-// To prevent a timer created with NewTimer from firing after a call
-// to Stop, check the return value and drain the
-// channel. https://golang.org/pkg/time/#Timer.Stop
+// Stops a timer created with Clock.AfterFunc.
 //
-// HOWEVER, it looks like a straight read of the timer's channel can
-// sometimes block even when Stop returns false. This works around
-// that by making the drain be non-blocking.
-func stopTimer(t *time.Timer) {
-	if !t.Stop() {
-		select {
-		case <-t.C:
-		default:
-		}
-	}
+// With a *time.Timer from time.AfterFunc there is no channel to drain
+// (unlike with NewTimer, https://golang.org/pkg/time/#Timer.Stop), so
+// this simply calls Stop.
+func stopTimer(t Timer) {
+	t.Stop()
 }
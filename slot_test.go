@@ -26,3 +26,49 @@ func TestRound(t *testing.T) {
 		}
 	}
 }
+
+func TestLimitBN(t *testing.T) {
+	ch := make(chan *Msg, 1)
+	node := NewNode("x", slicesToQSet([]NodeIDSet{{"a"}}), ch, nil)
+	s, err := newSlot(1, node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.cancelRounds()
+	defer s.cancelUpd()
+	defer s.cancelBump()
+
+	s.Ph = PhPrep
+	s.B = Ballot{N: MaxBallotCounter, X: valtype(1)}
+	s.M["a"] = &Msg{
+		V: "a",
+		I: 1,
+		T: &PrepTopic{B: Ballot{N: 5000, X: valtype(1)}},
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.updateB()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(BallotCounterInterval / 2):
+		t.Fatal("updateB blocked")
+	}
+
+	if s.B.N != MaxBallotCounter {
+		t.Errorf("got B.N = %d, want %d", s.B.N, MaxBallotCounter)
+	}
+	if s.pendingBN != MaxBallotCounter+1 {
+		t.Errorf("got pendingBN = %d, want %d", s.pendingBN, MaxBallotCounter+1)
+	}
+	if s.bump == nil {
+		t.Fatal("no ballot-counter increase scheduled")
+	}
+
+	s.bumpBN()
+	if s.B.N != MaxBallotCounter+1 {
+		t.Errorf("after bumpBN, got B.N = %d, want %d", s.B.N, MaxBallotCounter+1)
+	}
+}
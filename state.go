@@ -0,0 +1,382 @@
+package scp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// StateVersion is the version of the document produced by
+// Node.ExportState. Node.ImportState rejects documents with other
+// versions.
+const StateVersion = 1
+
+type (
+	jsonState struct {
+		Version int                  `json:"version"`
+		ID      NodeID               `json:"id"`
+		Watcher bool                 `json:"watcher,omitempty"`
+		Q       QSet                 `json:"q"`
+		QSets   []jsonQSetChange     `json:"qsets,omitempty"`
+		Ext     map[SlotID]jsonTopic `json:"ext,omitempty"`
+		Slots   []*jsonSlot          `json:"slots,omitempty"`
+		Watched []*jsonMsg           `json:"watched,omitempty"`
+		Parked  []*jsonMsg           `json:"parked,omitempty"`
+	}
+
+	jsonQSetChange struct {
+		From SlotID `json:"from"`
+		Q    QSet   `json:"q"`
+	}
+
+	jsonSlot struct {
+		ID   SlotID     `json:"id"`
+		Ph   Phase      `json:"ph"`
+		M    []*jsonMsg `json:"m,omitempty"`
+		Sent *jsonMsg   `json:"sent,omitempty"`
+
+		T time.Time `json:"t"`
+		X [][]byte  `json:"x,omitempty"`
+		Y [][]byte  `json:"y,omitempty"`
+		Z [][]byte  `json:"z,omitempty"`
+
+		MaxPriPeers NodeIDSet `json:"max_pri_peers,omitempty"`
+		LastRound   int       `json:"last_round"`
+
+		B  *jsonBallot `json:"b,omitempty"`
+		P  *jsonBallot `json:"p,omitempty"`
+		PP *jsonBallot `json:"pp,omitempty"`
+		C  *jsonBallot `json:"c,omitempty"`
+		H  *jsonBallot `json:"h,omitempty"`
+
+		// Which timers are armed.
+		Rounds    bool `json:"rounds,omitempty"`
+		Upd       bool `json:"upd,omitempty"`
+		PendingBN int  `json:"pending_bn,omitempty"`
+	}
+)
+
+// ExportState produces a JSON document holding the node's consensus
+// state: its quorum slices, including changes made with SetQSet, its
+// externalized values, the full state of each pending slot, the
+// messages a watcher holds for the slots it is watching, and any
+// parked messages (see SlotLimits). Values are encoded using their
+// Bytes methods.
+//
+// The node produces the document when it next processes commands
+// (with Run or Step), in order with the messages it handles, and
+// ExportState waits for it. It returns ctx's error if ctx is canceled
+// first. A caller driving the node with Step must therefore call
+// ExportState from another goroutine.
+func (n *Node) ExportState(ctx context.Context) ([]byte, error) {
+	result := make(chan exportResult, 1)
+	n.cmds.write(&exportStateCmd{result: result})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-result:
+		return r.state, r.err
+	}
+}
+
+type exportResult struct {
+	state []byte
+	err   error
+}
+
+// Produces the document for ExportState. It must be called on the
+// node's goroutine.
+func (n *Node) exportState() ([]byte, error) {
+	js := jsonState{
+		Version: StateVersion,
+		ID:      n.ID,
+		Watcher: n.IsWatcher(),
+		Q:       n.Q,
+	}
+	for _, c := range n.qsets {
+		js.QSets = append(js.QSets, jsonQSetChange{From: c.from, Q: c.q})
+	}
+	js.Ext = encodeExt(n.ext)
+	for _, s := range n.pending {
+		js.Slots = append(js.Slots, s.encode())
+	}
+	sort.Slice(js.Slots, func(i, j int) bool { return js.Slots[i].ID < js.Slots[j].ID })
+	js.Watched = encodeMsgMap(n.watched)
+	js.Parked = encodeMsgMap(n.parked)
+	return json.Marshal(js)
+}
+
+// Encodes a node's externalized values, or returns nil if there are
+// none.
+func encodeExt(ext map[SlotID]*ExtTopic) map[SlotID]jsonTopic {
+	if len(ext) == 0 {
+		return nil
+	}
+	result := make(map[SlotID]jsonTopic)
+	for slotID, topic := range ext {
+		result[slotID] = encodeTopic(topic)
+	}
+	return result
+}
+
+// The inverse of encodeExt.
+func decodeExt(m map[SlotID]jsonTopic, dec ValueDecoder) (map[SlotID]*ExtTopic, error) {
+	result := make(map[SlotID]*ExtTopic)
+	for slotID, jt := range m {
+		topic, err := jt.decode(dec)
+		if err != nil {
+			return nil, fmt.Errorf("slot %d: %s", slotID, err)
+		}
+		extTopic, ok := topic.(*ExtTopic)
+		if !ok {
+			return nil, fmt.Errorf("slot %d: externalized topic has type %s", slotID, jt.Type)
+		}
+		result[slotID] = extTopic
+	}
+	return result, nil
+}
+
+// Encodes the messages in m in order of slot and then sender.
+func encodeMsgMap(m map[SlotID]map[NodeID]*Msg) []*jsonMsg {
+	var slotIDs []SlotID
+	for slotID := range m {
+		slotIDs = append(slotIDs, slotID)
+	}
+	sort.Slice(slotIDs, func(i, j int) bool { return slotIDs[i] < slotIDs[j] })
+	var result []*jsonMsg
+	for _, slotID := range slotIDs {
+		var senders NodeIDSet
+		for nodeID := range m[slotID] {
+			senders = senders.Add(nodeID)
+		}
+		for _, nodeID := range senders {
+			result = append(result, encodeMsg(m[slotID][nodeID]))
+		}
+	}
+	return result
+}
+
+// The inverse of encodeMsgMap. It also tells the number of messages.
+func decodeMsgMap(jms []*jsonMsg, dec ValueDecoder) (map[SlotID]map[NodeID]*Msg, int, error) {
+	var (
+		result = make(map[SlotID]map[NodeID]*Msg)
+		count  int
+	)
+	for _, jm := range jms {
+		msg, err := jm.decode(dec)
+		if err != nil {
+			return nil, 0, err
+		}
+		msgs, ok := result[msg.I]
+		if !ok {
+			msgs = make(map[NodeID]*Msg)
+			result[msg.I] = msgs
+		}
+		if _, ok := msgs[msg.V]; !ok {
+			count++
+		}
+		msgs[msg.V] = msg
+	}
+	return result, count, nil
+}
+
+func (s *Slot) encode() *jsonSlot {
+	js := &jsonSlot{
+		ID:          s.ID,
+		Ph:          s.Ph,
+		T:           s.T,
+		X:           encodeValueSet(s.X),
+		Y:           encodeValueSet(s.Y),
+		Z:           encodeValueSet(s.Z),
+		MaxPriPeers: s.maxPriPeers,
+		LastRound:   s.lastRound,
+		B:           encodeBallot(s.B),
+		P:           encodeBallot(s.P),
+		PP:          encodeBallot(s.PP),
+		C:           encodeBallot(s.C),
+		H:           encodeBallot(s.H),
+		Rounds:      s.nextRoundTimer != nil,
+		Upd:         s.Upd != nil,
+	}
+	if s.bump != nil {
+		js.PendingBN = s.pendingBN
+	}
+	var peerIDs NodeIDSet
+	for peerID := range s.M {
+		peerIDs = peerIDs.Add(peerID)
+	}
+	for _, peerID := range peerIDs {
+		js.M = append(js.M, encodeMsg(s.M[peerID]))
+	}
+	if s.sent != nil {
+		js.Sent = encodeMsg(s.sent)
+	}
+	return js
+}
+
+// ImportState replaces the node's consensus state with that in a
+// document produced by ExportState, using dec to reconstruct values.
+// The document must be for a node with the same ID. Externalized
+// values are placed in the ext map the node was created with.
+//
+// A watcher's state can be imported only into a watcher, and vice
+// versa.
+//
+// The document is decoded and checked immediately. The node replaces
+// its state when it next processes commands (with Run or Step), in
+// order with the messages it handles.
+//
+// The node's timers are re-armed using its Clock, which should be set
+// first. Nomination rounds and ballot-counter increases resume on
+// their original schedules, measured from each slot's creation time;
+// a pending deferred update starts its full delay over.
+func (n *Node) ImportState(b []byte, dec ValueDecoder) error {
+	var js jsonState
+	err := json.Unmarshal(b, &js)
+	if err != nil {
+		return err
+	}
+	if js.Version != StateVersion {
+		return fmt.Errorf("state version %d, want %d", js.Version, StateVersion)
+	}
+	if js.ID != n.ID {
+		return fmt.Errorf("state is for node %s, not %s", js.ID, n.ID)
+	}
+	if js.Watcher != n.IsWatcher() {
+		if js.Watcher {
+			return fmt.Errorf("state is for a watcher, but node %s is not one", n.ID)
+		}
+		return fmt.Errorf("state is not for a watcher, but node %s is one", n.ID)
+	}
+
+	st := &nodeState{
+		q:       js.Q,
+		pending: make(map[SlotID]*Slot),
+	}
+	if st.ext, err = decodeExt(js.Ext, dec); err != nil {
+		return err
+	}
+	for _, jsl := range js.Slots {
+		s, err := jsl.decode(n, dec)
+		if err != nil {
+			return fmt.Errorf("slot %d: %s", jsl.ID, err)
+		}
+		st.pending[s.ID] = s
+		st.armed = append(st.armed, jsl)
+	}
+	for _, c := range js.QSets {
+		st.qsets = append(st.qsets, qsetChange{from: c.From, q: c.Q})
+	}
+	st.watched, _, err = decodeMsgMap(js.Watched, dec)
+	if err != nil {
+		return fmt.Errorf("watched message: %s", err)
+	}
+	st.parked, st.nparked, err = decodeMsgMap(js.Parked, dec)
+	if err != nil {
+		return fmt.Errorf("parked message: %s", err)
+	}
+
+	n.cmds.write(&importStateCmd{state: st})
+	return nil
+}
+
+// A nodeState is a document decoded by ImportState, waiting to be
+// installed in the node.
+type nodeState struct {
+	q       QSet
+	qsets   []qsetChange
+	ext     map[SlotID]*ExtTopic
+	pending map[SlotID]*Slot
+	armed   []*jsonSlot // the encoded slots, telling which timers to re-arm
+
+	watched map[SlotID]map[NodeID]*Msg
+	parked  map[SlotID]map[NodeID]*Msg
+	nparked int
+}
+
+// Replaces the node's state with st. It must be called on the node's
+// goroutine.
+func (n *Node) importState(st *nodeState) {
+	for _, s := range n.pending {
+		s.cancelRounds()
+		s.cancelUpd()
+		s.cancelBump()
+	}
+	n.qsetsMu.Lock()
+	n.Q = st.q
+	n.qsets = st.qsets
+	n.qsetsMu.Unlock()
+	n.pending = st.pending
+	if n.IsWatcher() {
+		n.watched = st.watched
+	}
+	n.parked = st.parked
+	n.nparked = st.nparked
+	n.extMu.Lock()
+	for slotID := range n.ext {
+		delete(n.ext, slotID)
+	}
+	for slotID, topic := range st.ext {
+		n.ext[slotID] = topic
+	}
+	n.extMu.Unlock()
+	for _, jsl := range st.armed {
+		s := st.pending[jsl.ID]
+		if jsl.Rounds {
+			s.scheduleRound()
+		}
+		if jsl.Upd {
+			s.maybeScheduleUpd()
+		}
+		if jsl.PendingBN > 0 {
+			s.scheduleBump(jsl.PendingBN)
+		}
+	}
+}
+
+func (jsl *jsonSlot) decode(n *Node, dec ValueDecoder) (*Slot, error) {
+	s := &Slot{
+		ID:          jsl.ID,
+		V:           n,
+		Ph:          jsl.Ph,
+		M:           make(map[NodeID]*Msg),
+		T:           jsl.T,
+		maxPriPeers: jsl.MaxPriPeers,
+		lastRound:   jsl.LastRound,
+	}
+	for _, jm := range jsl.M {
+		msg, err := jm.decode(dec)
+		if err != nil {
+			return nil, err
+		}
+		s.M[msg.V] = msg
+	}
+	if jsl.Sent != nil {
+		msg, err := jsl.Sent.decode(dec)
+		if err != nil {
+			return nil, err
+		}
+		s.sent = msg
+	}
+
+	var err error
+	for _, vs := range []struct {
+		dst *ValueSet
+		src [][]byte
+	}{{&s.X, jsl.X}, {&s.Y, jsl.Y}, {&s.Z, jsl.Z}} {
+		if *vs.dst, err = decodeValueSet(vs.src, dec); err != nil {
+			return nil, err
+		}
+	}
+	for _, b := range []struct {
+		dst *Ballot
+		src *jsonBallot
+	}{{&s.B, jsl.B}, {&s.P, jsl.P}, {&s.PP, jsl.PP}, {&s.C, jsl.C}, {&s.H, jsl.H}} {
+		if *b.dst, err = b.src.decode(dec); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,244 @@
+package scp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestExportImportState(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	ch := make(chan *Msg, 100)
+
+	ids := []NodeID{"a", "b", "c"}
+	nodes := make(map[NodeID]*Node)
+	newNode := func(id NodeID) *Node {
+		var others []NodeIDSet
+		for _, other := range ids {
+			if other != id {
+				others = append(others, NodeIDSet{other})
+			}
+		}
+		n := NewNode(id, slicesToQSet(others), ch, nil)
+		n.Clock = clock
+		return n
+	}
+	for _, id := range ids {
+		nodes[id] = newNode(id)
+	}
+
+	// Delivers queued messages until the network is quiet or until
+	// stop says to.
+	run := func(stop func() bool) {
+		for progress := true; progress; {
+			progress = false
+			for _, id := range ids {
+				for nodes[id].Step() {
+					progress = true
+					for len(ch) > 0 {
+						msg := <-ch
+						for _, other := range ids {
+							if other != msg.V {
+								nodes[other].Handle(msg)
+							}
+						}
+					}
+					if stop() {
+						return
+					}
+				}
+			}
+		}
+	}
+
+	for i, id := range ids {
+		n := nodes[id]
+		n.Handle(NewMsg(id, 1, n.Q, &NomTopic{X: ValueSet{valtype(i + 1)}}))
+	}
+
+	// Stop once node a is balloting.
+	run(func() bool {
+		s := nodes["a"].pending[1]
+		return s != nil && s.Ph >= PhPrep
+	})
+	s := nodes["a"].pending[1]
+	if s == nil || s.Ph < PhPrep {
+		t.Fatal("node a did not reach the PREPARE phase")
+	}
+
+	state, err := nodes["a"].exportState()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a2 := newNode("a")
+	err = a2.ImportState(state, decodeValtype)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a2.Step() // install the state
+	state2, err := a2.exportState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(state, state2) {
+		t.Errorf("re-exported state differs:\n%s\nvs.\n%s", state2, state)
+	}
+	if got, want := a2.pending[1].Msg().T.String(), s.Msg().T.String(); got != want {
+		t.Errorf("imported slot says %s, want %s", got, want)
+	}
+
+	err = newNode("b").ImportState(state, decodeValtype)
+	if err == nil {
+		t.Error("importing a's state into b succeeded, want error")
+	}
+
+	// The imported node should be able to finish the slot in place of
+	// the original.
+	nodes["a"] = a2
+	for iter := 0; iter < 10000; iter++ {
+		run(func() bool { return false })
+		done := true
+		for _, id := range ids {
+			if _, ok := nodes[id].ext[1]; !ok {
+				done = false
+			}
+		}
+		if done {
+			return
+		}
+		if !clock.fire() {
+			break
+		}
+	}
+	t.Fatal("network did not externalize")
+}
+
+func TestExportImportHeldMessages(t *testing.T) {
+	q := slicesToQSet([]NodeIDSet{{"b"}, {"c"}})
+	ext := func() map[SlotID]*ExtTopic {
+		return map[SlotID]*ExtTopic{1: {C: Ballot{N: 1, X: valtype(1)}, HN: 1}}
+	}
+	nom := func(from NodeID, i SlotID) *Msg {
+		var others []NodeIDSet
+		for _, id := range []NodeID{"a", "b", "c"} {
+			if id != from {
+				others = append(others, NodeIDSet{id})
+			}
+		}
+		return NewMsg(from, i, slicesToQSet(others), &NomTopic{X: ValueSet{valtype(i)}})
+	}
+	roundTrip := func(n, n2 *Node) {
+		t.Helper()
+		state, err := n.exportState()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = n2.ImportState(state, decodeValtype); err != nil {
+			t.Fatal(err)
+		}
+		n2.Step()
+		state2, err := n2.exportState()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(state, state2) {
+			t.Errorf("re-exported state differs:\n%s\nvs.\n%s", state2, state)
+		}
+	}
+
+	// Slot 3 is parked until slot 2 is externalized.
+	n := NewNode("a", q, nil, ext())
+	for _, msg := range []*Msg{nom("b", 3), nom("c", 3), nom("b", 2)} {
+		if err := n.handle(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n2 := NewNode("a", q, nil, nil)
+	roundTrip(n, n2)
+	if n2.nparked != 2 || len(n2.parked[3]) != 2 {
+		t.Errorf("imported %d parked message(s) (%v), want 2 for slot 3", n2.nparked, n2.parked)
+	}
+
+	w := NewWatcher("w", ext())
+	for _, msg := range []*Msg{nom("b", 2), nom("c", 2), nom("b", 1000)} {
+		if err := w.handle(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w2 := NewWatcher("w", nil)
+	roundTrip(w, w2)
+	if len(w2.watched[2]) != 2 {
+		t.Errorf("imported watched messages %v, want 2 for slot 2", w2.watched)
+	}
+	if w2.nparked != 1 {
+		t.Errorf("imported %d parked message(s), want 1", w2.nparked)
+	}
+
+	// A watcher's state does not fit an ordinary node, and vice versa.
+	state, err := w.exportState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = NewNode("w", q, nil, nil).ImportState(state, decodeValtype); err == nil {
+		t.Error("imported a watcher's state into an ordinary node")
+	}
+	state, err = n.exportState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = NewWatcher("a", nil).ImportState(state, decodeValtype); err == nil {
+		t.Error("imported an ordinary node's state into a watcher")
+	}
+}
+
+func TestExportStateWhileRunning(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	q := slicesToQSet([]NodeIDSet{{"b"}, {"c"}})
+	ch := make(chan *Msg, 100)
+	newNode := func() *Node {
+		n := NewNode("a", q, ch, nil)
+		n.Clock = &fakeClock{now: time.Unix(1000, 0)} // timers never fire
+		go n.Run(ctx)
+		return n
+	}
+
+	// The export is queued after the message, so it includes it.
+	n := newNode()
+	n.Handle(NewMsg("b", 1, slicesToQSet([]NodeIDSet{{"a"}, {"c"}}), &NomTopic{X: ValueSet{valtype(1)}}))
+	state, err := n.ExportState(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var js jsonState
+	if err = json.Unmarshal(state, &js); err != nil {
+		t.Fatal(err)
+	}
+	if len(js.Slots) != 1 || len(js.Slots[0].M) != 1 || js.Slots[0].M[0].V != "b" {
+		t.Errorf("exported slots %+v, want slot 1 with b's message", js.Slots)
+	}
+
+	n2 := newNode()
+	if err = n2.ImportState(state, decodeValtype); err != nil {
+		t.Fatal(err)
+	}
+	state2, err := n2.ExportState(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(state, state2) {
+		t.Errorf("re-exported state differs:\n%s\nvs.\n%s", state2, state)
+	}
+
+	// A node that is not running never answers.
+	ctx2, cancel2 := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel2()
+	if _, err = NewNode("a", q, ch, nil).ExportState(ctx2); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,333 @@
+package scp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// A trace is a sequence of JSON objects, one per line. The first
+// describes the traced node. After that, each "input" event (an
+// inbound message, the firing of one of the node's timers, or a
+// change to its quorum slices) is followed by the "output" events it
+// caused: outbound messages and changes to the phases of the node's
+// slots.
+
+// Kinds of trace events.
+const (
+	traceNode     = "node"
+	traceMsg      = "msg"
+	traceDeferred = "deferred-update"
+	traceBump     = "bump"
+	traceNewRound = "new-round"
+	traceRehandle = "rehandle"
+	traceQSet     = "qset"
+	traceSend     = "send"
+	tracePhase    = "phase"
+)
+
+type traceEvent struct {
+	Kind   string      `json:"kind"`
+	Time   time.Time   `json:"time"`
+	Slot   SlotID      `json:"slot,omitempty"`
+	Msg    *jsonMsg    `json:"msg,omitempty"`
+	Phase  *Phase      `json:"phase,omitempty"`
+	Node   NodeID      `json:"node,omitempty"`
+	Q      *QSet       `json:"q,omitempty"`
+	Limits *SlotLimits `json:"limits,omitempty"`
+
+	// In the node event, whether the node is a watcher and the values
+	// it had externalized when tracing began.
+	Watcher bool                 `json:"watcher,omitempty"`
+	Ext     map[SlotID]jsonTopic `json:"ext,omitempty"`
+}
+
+type tracer struct {
+	enc    *json.Encoder
+	phases map[SlotID]Phase
+	err    error
+}
+
+// Trace causes n to record its activity to w: every inbound message,
+// every timer firing, every outbound message, and every phase
+// transition. Replay can later feed the trace to a fresh Node to
+// reproduce the run. Trace must be called before n begins processing
+// messages.
+func (n *Node) Trace(w io.Writer) {
+	n.tracer = &tracer{
+		enc:    json.NewEncoder(w),
+		phases: make(map[SlotID]Phase),
+	}
+	n.extMu.RLock()
+	ext := encodeExt(n.ext)
+	n.extMu.RUnlock()
+	n.tracer.write(n, &traceEvent{
+		Kind:    traceNode,
+		Node:    n.ID,
+		Q:       &n.Q,
+		Limits:  &n.Limits,
+		Watcher: n.IsWatcher(),
+		Ext:     ext,
+	})
+}
+
+func (t *tracer) write(n *Node, ev *traceEvent) {
+	if t.err != nil {
+		return
+	}
+	ev.Time = n.Clock.Now()
+	t.err = t.enc.Encode(ev)
+	if t.err != nil {
+		n.Logf("ERROR writing trace, tracing stopped: %s", t.err)
+	}
+}
+
+func (t *tracer) input(n *Node, cmd Cmd) {
+	var ev *traceEvent
+	switch cmd := cmd.(type) {
+	case *msgCmd:
+		ev = &traceEvent{Kind: traceMsg, Msg: encodeMsg(cmd.msg)}
+	case *deferredUpdateCmd:
+		ev = &traceEvent{Kind: traceDeferred, Slot: cmd.slot.ID}
+	case *bumpBNCmd:
+		ev = &traceEvent{Kind: traceBump, Slot: cmd.slot.ID}
+	case *newRoundCmd:
+		ev = &traceEvent{Kind: traceNewRound, Slot: cmd.slot.ID}
+	case *rehandleCmd:
+		ev = &traceEvent{Kind: traceRehandle, Slot: cmd.slot.ID}
+	case *setQSetCmd:
+		q := cmd.q
+		ev = &traceEvent{Kind: traceQSet, Slot: cmd.from, Q: &q}
+	default:
+		return
+	}
+	t.write(n, ev)
+}
+
+func (t *tracer) send(n *Node, msg *Msg) {
+	t.write(n, &traceEvent{Kind: traceSend, Msg: encodeMsg(msg)})
+}
+
+// Records phase transitions in n's slots since the last call.
+func (t *tracer) checkPhases(n *Node) {
+	for slotID, s := range n.pending {
+		t.checkPhase(n, slotID, s.Ph)
+	}
+	for slotID := range t.phases {
+		if _, ok := n.pending[slotID]; ok {
+			continue
+		}
+		if _, ok := n.ext[slotID]; ok {
+			t.checkPhase(n, slotID, PhExt)
+		}
+		delete(t.phases, slotID)
+	}
+}
+
+func (t *tracer) checkPhase(n *Node, slotID SlotID, ph Phase) {
+	if old, ok := t.phases[slotID]; ok && old == ph {
+		return
+	}
+	t.phases[slotID] = ph
+	t.write(n, &traceEvent{Kind: tracePhase, Slot: slotID, Phase: &ph})
+}
+
+// Replay reads a trace produced by Node.Trace and feeds its input
+// events to a fresh Node with the traced node's ID, quorum slices,
+// and Limits, and the values it had externalized when tracing began.
+// The fresh node is a watcher if the traced one was. Replay checks
+// that the new node produces the same outbound messages and phase
+// transitions as the traced one, returning an error describing the
+// first divergence. The function dec reconstructs the values in the
+// trace's messages.
+func Replay(r io.Reader, dec ValueDecoder) error {
+	var (
+		events  []*traceEvent
+		scanner = bufio.NewScanner(r)
+	)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		var ev traceEvent
+		err := json.Unmarshal(scanner.Bytes(), &ev)
+		if err != nil {
+			return fmt.Errorf("parsing trace event %d: %s", len(events)+1, err)
+		}
+		events = append(events, &ev)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(events) == 0 || events[0].Kind != traceNode || events[0].Q == nil {
+		return fmt.Errorf("trace does not begin with a node description")
+	}
+
+	ch := make(chan *Msg)
+	defer close(ch)
+	go func() {
+		for range ch {
+		}
+	}()
+
+	ext, err := decodeExt(events[0].Ext, dec)
+	if err != nil {
+		return fmt.Errorf("node event: %s", err)
+	}
+	clock := new(replayClock)
+	var n *Node
+	if events[0].Watcher {
+		n = NewWatcher(events[0].Node, ext)
+	} else {
+		n = NewNode(events[0].Node, *events[0].Q, ch, ext)
+	}
+	n.Clock = clock
+	if events[0].Limits != nil {
+		n.Limits = *events[0].Limits
+	}
+
+	var got []*traceEvent
+	rec := &traceRecorder{events: &got}
+	n.tracer = &tracer{
+		enc:    json.NewEncoder(rec),
+		phases: make(map[SlotID]Phase),
+	}
+
+	// Slots seen so far, including ones that have since been
+	// externalized, since timers and rehandle commands may refer to
+	// them.
+	slots := make(map[SlotID]*Slot)
+
+	for i := 1; i < len(events); {
+		ev := events[i]
+		i++
+
+		var want []*traceEvent
+		for i < len(events) && (events[i].Kind == traceSend || events[i].Kind == tracePhase) {
+			want = append(want, events[i])
+			i++
+		}
+
+		clock.now = ev.Time
+
+		var cmd Cmd
+		switch ev.Kind {
+		case traceMsg:
+			msg, err := ev.Msg.decode(dec)
+			if err != nil {
+				return fmt.Errorf("event %d: decoding message: %s", i, err)
+			}
+			cmd = &msgCmd{msg: msg}
+
+		case traceDeferred, traceBump, traceNewRound, traceRehandle:
+			s, ok := slots[ev.Slot]
+			if !ok {
+				return fmt.Errorf("event %d: %s for unknown slot %d", i, ev.Kind, ev.Slot)
+			}
+			switch ev.Kind {
+			case traceDeferred:
+				cmd = &deferredUpdateCmd{slot: s}
+			case traceBump:
+				cmd = &bumpBNCmd{slot: s}
+			case traceNewRound:
+				cmd = &newRoundCmd{slot: s}
+			case traceRehandle:
+				cmd = &rehandleCmd{slot: s}
+			}
+
+		case traceQSet:
+			if ev.Q == nil {
+				return fmt.Errorf("event %d: %s event without a QSet", i, ev.Kind)
+			}
+			cmd = &setQSetCmd{q: *ev.Q, from: ev.Slot}
+
+		default:
+			return fmt.Errorf("event %d: unexpected %s event", i, ev.Kind)
+		}
+
+		got = nil
+		n.exec(cmd)
+
+		// Commands the node queued for itself (e.g. rehandle after a
+		// new round) appear in the trace as their own input events.
+		for {
+			if _, ok := n.cmds.poll(); !ok {
+				break
+			}
+		}
+		for slotID, s := range n.pending {
+			slots[slotID] = s
+		}
+
+		// Skip the input event the node recorded for itself.
+		if len(got) > 0 {
+			got = got[1:]
+		}
+		if rec.err != nil {
+			return rec.err
+		}
+		if err := compareTraceEvents(got, want); err != nil {
+			return fmt.Errorf("after %s event at %s: %s", ev.Kind, ev.Time, err)
+		}
+	}
+	return nil
+}
+
+func compareTraceEvents(got, want []*traceEvent) error {
+	for i := 0; i < len(got) || i < len(want); i++ {
+		if i >= len(got) {
+			return fmt.Errorf("missing %s", traceEventString(want[i]))
+		}
+		if i >= len(want) {
+			return fmt.Errorf("unexpected %s", traceEventString(got[i]))
+		}
+		g, w := traceEventString(got[i]), traceEventString(want[i])
+		if g != w {
+			return fmt.Errorf("got %s, want %s", g, w)
+		}
+	}
+	return nil
+}
+
+// Describes a trace event for comparison purposes. Message counters
+// (Msg.C) are deliberately omitted.
+func traceEventString(ev *traceEvent) string {
+	switch ev.Kind {
+	case traceSend:
+		b, _ := json.Marshal(ev.Msg.T)
+		return fmt.Sprintf("send(V=%s I=%d %s)", ev.Msg.V, ev.Msg.I, b)
+	case tracePhase:
+		return fmt.Sprintf("phase(slot %d: %d)", ev.Slot, *ev.Phase)
+	}
+	return ev.Kind
+}
+
+// Collects the events written to a tracer's encoder.
+type traceRecorder struct {
+	events *[]*traceEvent
+	err    error
+}
+
+func (r *traceRecorder) Write(b []byte) (int, error) {
+	var ev traceEvent
+	if err := json.Unmarshal(b, &ev); err != nil {
+		r.err = err
+		return 0, err
+	}
+	*r.events = append(*r.events, &ev)
+	return len(b), nil
+}
+
+// A Clock whose time is set by Replay and whose timers never fire
+// (timer firings are replayed from the trace instead).
+type replayClock struct {
+	now time.Time
+}
+
+func (c *replayClock) Now() time.Time { return c.now }
+
+func (c *replayClock) AfterFunc(time.Duration, func()) Timer { return replayTimer{} }
+
+type replayTimer struct{}
+
+func (replayTimer) Stop() bool { return true }
@@ -0,0 +1,249 @@
+package scp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	at      time.Time
+	f       func()
+	stopped bool
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	t := &fakeTimer{at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (t *fakeTimer) Stop() bool {
+	wasStopped := t.stopped
+	t.stopped = true
+	return !wasStopped
+}
+
+// Fires the earliest pending timer, returning false if there are none.
+func (c *fakeClock) fire() bool {
+	sort.SliceStable(c.timers, func(i, j int) bool { return c.timers[i].at.Before(c.timers[j].at) })
+	for len(c.timers) > 0 {
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		if t.stopped {
+			continue
+		}
+		t.stopped = true
+		c.now = t.at
+		t.f()
+		return true
+	}
+	return false
+}
+
+func decodeValtype(b []byte) (Value, error) {
+	if len(b) != 4 {
+		return nil, fmt.Errorf("got %d bytes, want 4", len(b))
+	}
+	return valtype(binary.BigEndian.Uint32(b)), nil
+}
+
+// Runs a three-node network synchronously until all nodes externalize
+// slot 1, tracing node "a".
+func runTraced(t *testing.T) *bytes.Buffer {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	ch := make(chan *Msg, 100)
+
+	ids := []NodeID{"a", "b", "c"}
+	nodes := make(map[NodeID]*Node)
+	for _, id := range ids {
+		var others []NodeIDSet
+		for _, other := range ids {
+			if other != id {
+				others = append(others, NodeIDSet{other})
+			}
+		}
+		n := NewNode(id, slicesToQSet(others), ch, nil)
+		n.Clock = clock
+		nodes[id] = n
+	}
+
+	trace := new(bytes.Buffer)
+	nodes["a"].Trace(trace)
+
+	for i, id := range ids {
+		n := nodes[id]
+		n.Handle(NewMsg(id, 1, n.Q, &NomTopic{X: ValueSet{valtype(i + 1)}}))
+	}
+
+	for iter := 0; iter < 10000; iter++ {
+		for progress := true; progress; {
+			progress = false
+			for _, id := range ids {
+				for nodes[id].Step() {
+					progress = true
+					for len(ch) > 0 {
+						msg := <-ch
+						for _, other := range ids {
+							if other != msg.V {
+								nodes[other].Handle(msg)
+							}
+						}
+					}
+				}
+			}
+		}
+		done := true
+		for _, id := range ids {
+			if _, ok := nodes[id].ext[1]; !ok {
+				done = false
+			}
+		}
+		if done {
+			return trace
+		}
+		if !clock.fire() {
+			break
+		}
+	}
+	t.Fatal("network did not externalize")
+	return nil
+}
+
+func TestReplay(t *testing.T) {
+	trace := runTraced(t)
+	if !strings.Contains(trace.String(), `"kind":"send"`) {
+		t.Fatal("trace contains no outbound messages")
+	}
+
+	err := Replay(bytes.NewReader(trace.Bytes()), decodeValtype)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Remove the first outbound message from the trace; replay should
+	// now detect a divergence.
+	lines := strings.SplitAfter(trace.String(), "\n")
+	for i, line := range lines {
+		if strings.Contains(line, `"kind":"send"`) {
+			lines = append(lines[:i], lines[i+1:]...)
+			break
+		}
+	}
+	err = Replay(strings.NewReader(strings.Join(lines, "")), decodeValtype)
+	if err == nil {
+		t.Error("replay of altered trace succeeded, want error")
+	}
+}
+
+func TestMsgCodec(t *testing.T) {
+	topics := []Topic{
+		&NomTopic{X: ValueSet{valtype(1), valtype(2)}, Y: ValueSet{valtype(3)}},
+		&NomPrepTopic{
+			NomTopic:  NomTopic{X: ValueSet{valtype(1)}},
+			PrepTopic: PrepTopic{B: Ballot{2, valtype(1)}, P: Ballot{1, valtype(1)}, HN: 1, CN: 1},
+		},
+		&PrepTopic{B: Ballot{3, valtype(2)}, P: Ballot{2, valtype(2)}, PP: Ballot{1, valtype(1)}},
+		&CommitTopic{B: Ballot{3, valtype(2)}, PN: 3, HN: 3, CN: 2},
+		&ExtTopic{C: Ballot{2, valtype(2)}, HN: 3},
+	}
+	for i, topic := range topics {
+		t.Run(fmt.Sprintf("%02d", i+1), func(t *testing.T) {
+			msg := NewMsg("x", 7, slicesToQSet([]NodeIDSet{{"a", "b"}}), topic)
+			b, err := MarshalMsg(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := UnmarshalMsg(b, decodeValtype)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != msg.String() || !reflect.DeepEqual(got.Q, msg.Q) {
+				t.Errorf("got %s, want %s", got, msg)
+			}
+		})
+	}
+}
+
+func TestReplayNodeState(t *testing.T) {
+	ext := func() map[SlotID]*ExtTopic {
+		return map[SlotID]*ExtTopic{1: {C: Ballot{N: 1, X: valtype(1)}, HN: 1}}
+	}
+	qsetOf := func(self NodeID) QSet {
+		var others []NodeIDSet
+		for _, id := range []NodeID{"a", "b", "c"} {
+			if id != self {
+				others = append(others, NodeIDSet{id})
+			}
+		}
+		return slicesToQSet(others)
+	}
+	msgs := []*Msg{
+		NewMsg("b", 2, qsetOf("b"), &NomTopic{X: ValueSet{valtype(2)}}),
+		NewMsg("c", 2, qsetOf("c"), &NomTopic{X: ValueSet{valtype(2)}}),
+		NewMsg("b", 2, qsetOf("b"), &ExtTopic{C: Ballot{N: 1, X: valtype(2)}, HN: 1}),
+		NewMsg("c", 2, qsetOf("c"), &ExtTopic{C: Ballot{N: 1, X: valtype(2)}, HN: 1}),
+	}
+	traced := func(n *Node) string {
+		n.Clock = &fakeClock{now: time.Unix(1000, 0)}
+		trace := new(bytes.Buffer)
+		n.Trace(trace)
+		for _, msg := range msgs {
+			n.Handle(msg)
+		}
+		for n.Step() {
+		}
+		if _, ok := n.ext[2]; !ok {
+			t.Fatal("slot 2 not externalized")
+		}
+		return trace.String()
+	}
+	ch := make(chan *Msg, 100)
+
+	cases := []struct {
+		name  string
+		trace string
+		strip string // field removed from the node event to make replay fail
+	}{
+		{name: "node", trace: traced(NewNode("a", qsetOf("a"), ch, ext())), strip: "ext"},
+		{name: "watcher", trace: traced(NewWatcher("w", ext())), strip: "watcher"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := Replay(strings.NewReader(tc.trace), decodeValtype); err != nil {
+				t.Fatal(err)
+			}
+
+			lines := strings.SplitAfterN(tc.trace, "\n", 2)
+			var ev map[string]interface{}
+			if err := json.Unmarshal([]byte(lines[0]), &ev); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := ev[tc.strip]; !ok {
+				t.Fatalf("node event %s lacks %s", lines[0], tc.strip)
+			}
+			delete(ev, tc.strip)
+			first, err := json.Marshal(ev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			altered := string(first) + "\n" + lines[1]
+			if err := Replay(strings.NewReader(altered), decodeValtype); err == nil {
+				t.Errorf("replay without %s succeeded, want error", tc.strip)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package scp
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// NewWatcher produces a node that follows consensus without taking
+// part in it. A watcher has no quorum slices of its own and never
+// sends a message. It keeps the latest message from each peer for
+// each slot, and externalizes a value for the slot when some quorum,
+// under its members' own quorum slices, accepts commit for it (i.e.,
+// sends COMMIT or EXTERNALIZE messages for it). Such a quorum has
+// confirmed the commit, so its members externalize the same value.
+// A watcher rejects a message whose QSet is malformed (e.g. empty, or
+// with a zero threshold), which could make its sender a quorum alone.
+//
+// Feed a watcher messages with Handle and drive it with Run or Step,
+// as with any node. Read its results with Externalized, ExtRange, and
+// HighestExt.
+func NewWatcher(id NodeID, ext map[SlotID]*ExtTopic) *Node {
+	n := NewNode(id, QSet{}, nil, ext)
+	n.watched = make(map[SlotID]map[NodeID]*Msg)
+	return n
+}
+
+// IsWatcher tells whether n was created with NewWatcher.
+func (n *Node) IsWatcher() bool {
+	return n.watched != nil
+}
+
+// The watcher's counterpart of handle.
+func (n *Node) watch(msg *Msg) error {
+	if topic, ok := n.ext[msg.I]; ok {
+		if inTopic, ok := msg.T.(*ExtTopic); ok && !ValueEqual(inTopic.C.X, topic.C.X) {
+			n.disagree(msg, topic.C.X)
+		}
+		return nil
+	}
+	if err := msg.valid(); err != nil {
+		return err
+	}
+	// A watcher has no slices of its own, so the search for a quorum
+	// relies entirely on the senders' QSets. An empty QSet, or one
+	// with a zero threshold, would make its sender a quorum by itself.
+	if err := msg.Q.validate(msg.V); err != nil {
+		return fmt.Errorf("bad QSet: %s: %s", err, msg)
+	}
+
+	msgs, ok := n.watched[msg.I]
+	if !ok {
+		msgs = make(map[NodeID]*Msg)
+		n.watched[msg.I] = msgs
+	}
+	if have, ok := msgs[msg.V]; ok && !have.T.Less(msg.T) {
+		// Nothing new from this sender.
+		return nil
+	}
+	msgs[msg.V] = msg
+
+	var x Value
+	switch topic := msg.T.(type) {
+	case *CommitTopic:
+		x = topic.B.X
+	case *ExtTopic:
+		x = topic.C.X
+	default:
+		// Only a message accepting commit can complete a quorum that
+		// accepts commit.
+		return nil
+	}
+
+	topic, ok := n.findExtQuorum(msgs, x)
+	if !ok {
+		return nil
+	}
+	n.Logf("watched slot %d externalized %s", msg.I, VString(topic.C.X))
+	n.extMu.Lock()
+	n.ext[msg.I] = topic
+	n.extMu.Unlock()
+	delete(n.watched, msg.I)
+	n.unpark()
+	return nil
+}
+
+// Looks for a quorum, among the senders of msgs and under their own
+// QSets, in which every node accepts commit for some range of ballots
+// with value x. It tries a search from the perspective of each sender
+// that accepts, in a fixed order, since a quorum found from one need
+// not include the others.
+func (n *Node) findExtQuorum(msgs map[NodeID]*Msg, x Value) (*ExtTopic, bool) {
+	var senders NodeIDSet
+	for nodeID := range msgs {
+		senders = append(senders, nodeID)
+	}
+	sort.Slice(senders, func(i, j int) bool { return senders[i].Less(senders[j]) })
+
+	var cn, hn int
+	pred := &minMaxPred{
+		min:      1,
+		max:      math.MaxInt32,
+		finalMin: &cn,
+		finalMax: &hn,
+		testfn: func(msg *Msg, min, max int) (bool, int, int) {
+			return msg.acceptsCommit(x, min, max)
+		},
+	}
+	index := newNodeIndex()
+	for _, nodeID := range senders {
+		msg := msgs[nodeID]
+		// The search presumes its starting node satisfies the predicate,
+		// so test it here.
+		start := pred.test(msg)
+		if start == nil {
+			continue
+		}
+		if res, _ := msg.Q.findQuorumIn(index, nodeID, msgs, start); len(res) > 0 {
+			return &ExtTopic{C: Ballot{N: cn, X: x}, HN: hn}, true
+		}
+	}
+	return nil, false
+}
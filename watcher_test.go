@@ -0,0 +1,169 @@
+package scp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWatcher(t *testing.T) {
+	a, b, c := NodeID("a"), NodeID("b"), NodeID("c")
+	qset := func(others ...NodeID) QSet {
+		q := QSet{T: len(others)}
+		for i := range others {
+			q.M = append(q.M, QSetMember{N: &others[i]})
+		}
+		return q
+	}
+	qa, qb, qc := qset(b, c), qset(a, c), qset(a, b)
+	x, y := valtype(1), valtype(2)
+
+	w := NewWatcher("w", nil)
+	if !w.IsWatcher() {
+		t.Fatal("IsWatcher is false")
+	}
+	handle := func(msg *Msg) {
+		t.Helper()
+		if err := w.handle(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	extd := func(want Value) {
+		t.Helper()
+		got, ok := w.Externalized(1)
+		if want == nil {
+			if ok {
+				t.Fatalf("externalized %s early", VString(got))
+			}
+			return
+		}
+		if !ok || !ValueEqual(got, want) {
+			t.Fatalf("externalized %v (%v), want %s", got, ok, VString(want))
+		}
+	}
+
+	handle(NewMsg(a, 1, qa, &CommitTopic{B: Ballot{N: 3, X: x}, PN: 3, CN: 2, HN: 3}))
+	extd(nil)
+	handle(NewMsg(b, 1, qb, &CommitTopic{B: Ballot{N: 2, X: x}, PN: 2, CN: 1, HN: 2}))
+	extd(nil)
+	// A PREPARE from c leaves the quorum incomplete.
+	handle(NewMsg(c, 1, qc, &PrepTopic{B: Ballot{N: 2, X: x}, P: Ballot{N: 2, X: x}}))
+	extd(nil)
+	// A COMMIT from c for another value does too.
+	handle(NewMsg(c, 1, qc, &CommitTopic{B: Ballot{N: 2, X: y}, PN: 2, CN: 1, HN: 2}))
+	extd(nil)
+	// An EXTERNALIZE from c for the same value completes it.
+	handle(NewMsg(c, 1, qc, &ExtTopic{C: Ballot{N: 2, X: x}, HN: 4}))
+	extd(x)
+
+	if topic := w.ext[1]; topic.C.N != 2 || topic.HN != 2 {
+		t.Errorf("externalized %s, want C.N=2 HN=2", topic)
+	}
+	if len(w.watched) != 0 {
+		t.Errorf("watcher still holds messages for %d slot(s)", len(w.watched))
+	}
+	var disagreements int
+	w.OnDisagreement = func(*Disagreement) { disagreements++ }
+	handle(NewMsg(a, 1, qa, &ExtTopic{C: Ballot{N: 3, X: y}, HN: 3}))
+	if disagreements != 1 {
+		t.Errorf("disagreeing EXTERNALIZE gave %d disagreement(s), want 1", disagreements)
+	}
+}
+
+func TestWatcherLoneSender(t *testing.T) {
+	a, b, m := NodeID("a"), NodeID("b"), NodeID("m")
+	x := valtype(1)
+
+	cases := []struct {
+		name string
+		q    QSet
+	}{
+		{"empty", QSet{}},
+		{"zero threshold", QSet{T: 0, M: []QSetMember{{N: &a}, {N: &b}}}},
+		{"excessive threshold", QSet{T: 3, M: []QSetMember{{N: &a}, {N: &b}}}},
+		{"self", QSet{T: 1, M: []QSetMember{{N: &m}}}},
+		{"empty inner", QSet{T: 1, M: []QSetMember{{Q: &QSet{}}}}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := NewWatcher("w", nil)
+			err := w.handle(NewMsg(m, 1, tc.q, &ExtTopic{C: Ballot{N: 1, X: x}, HN: 1}))
+			if err == nil {
+				t.Error("no error")
+			}
+			if v, ok := w.Externalized(1); ok {
+				t.Errorf("externalized %s on the word of a lone sender", VString(v))
+			}
+		})
+	}
+}
+
+func TestWatcherFollowsNetwork(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	ch := make(chan *Msg, 1000)
+
+	ids := []NodeID{"n0", "n1", "n2", "n3"}
+	nodes := make(map[NodeID]*Node)
+	for _, id := range ids {
+		q := QSet{T: 2}
+		for i := range ids {
+			if ids[i] != id {
+				q.M = append(q.M, QSetMember{N: &ids[i]})
+			}
+		}
+		n := NewNode(id, q, ch, nil)
+		n.Clock = clock
+		nodes[id] = n
+	}
+	w := NewWatcher("w", nil)
+	w.Clock = clock
+
+	deliver := func() {
+		for len(ch) > 0 {
+			msg := <-ch
+			w.Handle(msg)
+			for _, other := range ids {
+				if other != msg.V {
+					nodes[other].Handle(msg)
+				}
+			}
+		}
+	}
+
+	for i, id := range ids {
+		n := nodes[id]
+		n.Handle(NewMsg(id, 1, n.Q, &NomTopic{X: ValueSet{valtype(i + 1)}}))
+	}
+	for iter := 0; iter < 1000; iter++ {
+		for progress := true; progress; {
+			progress = false
+			for _, id := range ids {
+				for nodes[id].Step() {
+					progress = true
+					deliver()
+				}
+			}
+			for w.Step() {
+				progress = true
+			}
+		}
+		if _, ok := w.Externalized(1); ok {
+			break
+		}
+		if !clock.fire() {
+			break
+		}
+	}
+
+	got, ok := w.Externalized(1)
+	if !ok {
+		t.Fatal("watcher did not externalize")
+	}
+	if len(ch) > 0 {
+		t.Errorf("%d message(s) left undelivered", len(ch))
+	}
+	for _, id := range ids {
+		if want, ok := nodes[id].Externalized(1); ok && !ValueEqual(got, want) {
+			t.Errorf("watcher externalized %s, node %s externalized %s", VString(got), id, VString(want))
+		}
+	}
+}